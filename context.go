@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// contextNames returns the configured context names in sorted order.
+func (s *Switcher) contextNames() []string {
+	names := make([]string, 0, len(s.config.Contexts))
+	for name := range s.config.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// contextApps returns the apps of a context in sorted order.
+func contextApps(ctx map[string]string) []string {
+	apps := make([]string, 0, len(ctx))
+	for app := range ctx {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	return apps
+}
+
+// ApplyContext switches every app of the named context to its profile. Apps
+// already on the right profile are left alone. If a switch fails, the apps
+// switched so far are reverted with Undo in reverse order.
+func (s *Switcher) ApplyContext(name string) error {
+	ctx, exists := s.config.Contexts[name]
+	if !exists {
+		return fmt.Errorf("context '%s' not found", name)
+	}
+	if len(ctx) == 0 {
+		return fmt.Errorf("context '%s' has no apps", name)
+	}
+
+	apps := contextApps(ctx)
+	for _, app := range apps {
+		appConfig, err := s.resolveApp(app)
+		if err != nil {
+			return fmt.Errorf("context '%s': %w", name, err)
+		}
+		if !contains(appConfig.Accounts, ctx[app]) {
+			return fmt.Errorf("context '%s': account '%s' not found for %s", name, ctx[app], app)
+		}
+	}
+
+	var switched []string
+	var unchanged int
+	for _, app := range apps {
+		profile := ctx[app]
+		if s.findCurrentAccount(app) == profile {
+			fmt.Printf("%s✓ %s already on %s%s\n", ColorGreen, app, profile, ColorReset)
+			unchanged++
+			continue
+		}
+		if err := s.SwitchAccount(app, profile); err != nil {
+			fmt.Printf("%s✗ %s: %v%s\n", ColorRed, app, err, ColorReset)
+			s.rollbackContext(switched)
+			return fmt.Errorf("context '%s' not applied: %s: %w", name, app, err)
+		}
+		switched = append(switched, app)
+	}
+
+	fmt.Printf("%s✓ Context %s applied (%d switched, %d unchanged)%s\n",
+		ColorGreen, name, len(switched), unchanged, ColorReset)
+	return nil
+}
+
+// rollbackContext undoes the switches of a partially applied context, most
+// recent first. Failures are reported but do not stop the rollback.
+func (s *Switcher) rollbackContext(switched []string) {
+	for i := len(switched) - 1; i >= 0; i-- {
+		if err := s.Undo(switched[i]); err != nil {
+			fmt.Printf("%s✗ Could not roll back %s: %v%s\n", ColorRed, switched[i], err, ColorReset)
+		}
+	}
+}
+
+// ListContexts prints the configured contexts and marks the ones whose
+// profiles are all currently active.
+func (s *Switcher) ListContexts() {
+	names := s.contextNames()
+	if len(names) == 0 {
+		fmt.Printf("No contexts configured\n")
+		fmt.Printf("Add them to ~/.switch.toml, e.g. [contexts.work] codex = \"work\"\n")
+		return
+	}
+
+	fmt.Printf("%sContexts:%s\n", ColorCyan, ColorReset)
+	for _, name := range names {
+		ctx := s.config.Contexts[name]
+		active := len(ctx) > 0
+		var pairs []string
+		for _, app := range contextApps(ctx) {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", app, ctx[app]))
+			if s.findCurrentAccount(app) != ctx[app] {
+				active = false
+			}
+		}
+		if active {
+			fmt.Printf("%s→ %-10s%s %s (active)\n", ColorGreen, name, ColorReset, strings.Join(pairs, " "))
+		} else {
+			fmt.Printf("  %-10s %s\n", name, strings.Join(pairs, " "))
+		}
+	}
+}
+
+// renameAppInContexts keeps contexts pointing at an app after it is renamed.
+func (s *Switcher) renameAppInContexts(oldName, newName string) {
+	for _, ctx := range s.config.Contexts {
+		if profile, ok := ctx[oldName]; ok {
+			delete(ctx, oldName)
+			ctx[newName] = profile
+		}
+	}
+}
+
+// renameProfileInContexts keeps contexts pointing at a profile after it is
+// renamed.
+func (s *Switcher) renameProfileInContexts(appName, oldName, newName string) {
+	for _, ctx := range s.config.Contexts {
+		if ctx[appName] == oldName {
+			ctx[appName] = newName
+		}
+	}
+}
+
+// removeProfileFromContexts drops appName from the contexts that switch it
+// to profile, which is being removed, and returns their names. Contexts left
+// without apps are removed too.
+func (s *Switcher) removeProfileFromContexts(appName, profile string) []string {
+	var changed []string
+	for _, name := range s.contextNames() {
+		ctx := s.config.Contexts[name]
+		if ctx[appName] != profile {
+			continue
+		}
+		delete(ctx, appName)
+		if len(ctx) == 0 {
+			delete(s.config.Contexts, name)
+		}
+		changed = append(changed, name)
+	}
+	return changed
+}
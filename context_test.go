@@ -0,0 +1,187 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setupContextApps(t *testing.T) (*Switcher, string, string) {
+	t.Helper()
+	home := setHome(t)
+	codexAuth := setupCodexFiles(t, home, `{"token":"personal"}`, map[string]string{"personal": `{"token":"personal"}`, "work": `{"token":"work"}`})
+	claudeDir := filepath.Join(home, ".claude")
+	os.MkdirAll(claudeDir, 0755)
+	claudeAuth := filepath.Join(claudeDir, "config.json")
+	os.WriteFile(claudeAuth, []byte(`{"user":"personal"}`), 0600)
+	os.WriteFile(claudeAuth+".personal.switch", []byte(`{"user":"personal"}`), 0600)
+	os.WriteFile(claudeAuth+".work.switch", []byte(`{"user":"work"}`), 0600)
+
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "personal", Accounts: []string{"personal", "work"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	s.SetAppConfig("claude", AppConfig{Current: "personal", Accounts: []string{"personal", "work"}, AuthPath: "~/.claude/config.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	s.config.Contexts = map[string]map[string]string{
+		"work":     {"codex": "work", "claude": "work"},
+		"personal": {"codex": "personal", "claude": "personal"},
+		"mixed":    {"codex": "work", "claude": "personal"},
+	}
+	if err := s.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	return s, codexAuth, claudeAuth
+}
+
+func TestApplyContext(t *testing.T) {
+	s, codexAuth, claudeAuth := setupContextApps(t)
+	out, _ := captureOutput(t, func() {
+		if code := handleContext(s, []string{"work"}); code != 0 {
+			t.Fatalf("context work failed: %d", code)
+		}
+	})
+	if b, _ := os.ReadFile(codexAuth); string(b) != `{"token":"work"}` {
+		t.Fatalf("codex not switched: %s", b)
+	}
+	if b, _ := os.ReadFile(claudeAuth); string(b) != `{"user":"work"}` {
+		t.Fatalf("claude not switched: %s", b)
+	}
+	if !strings.Contains(out, "2 switched, 0 unchanged") {
+		t.Fatalf("unexpected summary: %q", out)
+	}
+
+	// Apps already on the right profile are left alone
+	out, _ = captureOutput(t, func() {
+		if err := s.ApplyContext("mixed"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "codex already on work") || !strings.Contains(out, "1 switched, 1 unchanged") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+
+	// Contexts survive a config reload
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if s.config.Contexts["work"]["claude"] != "work" {
+		t.Fatalf("contexts not persisted: %+v", s.config.Contexts)
+	}
+}
+
+func TestApplyContext_Validation(t *testing.T) {
+	s, codexAuth, _ := setupContextApps(t)
+	if err := s.ApplyContext("nosuch"); err == nil {
+		t.Fatalf("expected unknown context error")
+	}
+	s.config.Contexts["broken"] = map[string]string{"codex": "work", "ghost": "x"}
+	if err := s.ApplyContext("broken"); err == nil || !strings.Contains(err.Error(), "ghost") {
+		t.Fatalf("expected unknown app error, got %v", err)
+	}
+	s.config.Contexts["broken"] = map[string]string{"codex": "missing"}
+	if err := s.ApplyContext("broken"); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected unknown profile error, got %v", err)
+	}
+	// Nothing was switched by the failed validations
+	if b, _ := os.ReadFile(codexAuth); string(b) != `{"token":"personal"}` {
+		t.Fatalf("codex changed by invalid context: %s", b)
+	}
+	if code := handleContext(s, []string{"a", "b"}); code != 1 {
+		t.Fatalf("expected usage error")
+	}
+}
+
+func TestApplyContext_RollsBackOnFailure(t *testing.T) {
+	s, codexAuth, claudeAuth := setupContextApps(t)
+	// claude is applied before codex; make the codex switch fail
+	os.Remove(codexAuth + ".work.switch")
+	if err := s.ApplyContext("work"); err == nil {
+		t.Fatalf("expected context to fail")
+	}
+	if b, _ := os.ReadFile(claudeAuth); string(b) != `{"user":"personal"}` {
+		t.Fatalf("claude not rolled back: %s", b)
+	}
+	if app, _ := s.GetAppConfig("claude"); app.Current != "personal" {
+		t.Fatalf("claude current not rolled back: %+v", app)
+	}
+	if b, _ := os.ReadFile(codexAuth); string(b) != `{"token":"personal"}` {
+		t.Fatalf("codex changed: %s", b)
+	}
+	if entries, _ := s.loadHistory(); len(entries) != 0 {
+		t.Fatalf("rollback should leave no history, got %+v", entries)
+	}
+}
+
+func TestListContexts(t *testing.T) {
+	s, _, _ := setupContextApps(t)
+	out, _ := captureOutput(t, func() { s.ListContexts() })
+	m, p, w := strings.Index(out, "mixed"), strings.Index(out, "personal "), strings.Index(out, "work ")
+	if !(m < p && p < w) {
+		t.Fatalf("contexts not sorted: %q", out)
+	}
+	if !strings.Contains(out, "claude=personal codex=personal (active)") {
+		t.Fatalf("personal context should be active: %q", out)
+	}
+	s.config.Contexts = nil
+	out, _ = captureOutput(t, func() { s.ListContexts() })
+	if !strings.Contains(out, "No contexts configured") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestRename_UpdatesContexts(t *testing.T) {
+	s, _, _ := setupContextApps(t)
+	if err := s.RenameAccount("codex", "work", "job"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RenameApp("claude", "anthropic"); err != nil {
+		t.Fatal(err)
+	}
+	work := s.config.Contexts["work"]
+	if work["codex"] != "job" || work["anthropic"] != "work" || work["claude"] != "" {
+		t.Fatalf("contexts not updated: %+v", work)
+	}
+	if s.config.Contexts["personal"]["codex"] != "personal" {
+		t.Fatalf("unrelated profile changed: %+v", s.config.Contexts["personal"])
+	}
+}
+
+func TestRemoveAccount_UpdatesContextsAndHistory(t *testing.T) {
+	s, codexAuth, _ := setupContextApps(t)
+	if err := s.SwitchAccount("codex", "work"); err != nil {
+		t.Fatal(err)
+	}
+	s.config.Contexts["solo"] = map[string]string{"codex": "personal"}
+	out, _ := captureOutput(t, func() {
+		if err := s.RemoveAccount("codex", "personal", true); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "Removed codex from context personal") || !strings.Contains(out, "context solo") {
+		t.Fatalf("context changes not reported: %q", out)
+	}
+	if _, ok := s.config.Contexts["personal"]["codex"]; ok {
+		t.Fatalf("context still references the removed profile")
+	}
+	if _, ok := s.config.Contexts["solo"]; ok {
+		t.Fatalf("empty context not removed")
+	}
+	if s.config.Contexts["mixed"]["codex"] != "work" {
+		t.Fatalf("unrelated context changed: %v", s.config.Contexts["mixed"])
+	}
+	if err := s.ApplyContext("personal"); err != nil {
+		t.Fatalf("context no longer applies: %v", err)
+	}
+
+	entries, _ := s.loadHistory()
+	for _, e := range entries {
+		if e.App == "codex" && e.From == "personal" {
+			t.Fatalf("history still points at the removed profile: %+v", e)
+		}
+	}
+	if err := s.SwitchBack("codex"); err == nil {
+		t.Fatalf("expected no profile to switch back to")
+	}
+	if b, _ := os.ReadFile(codexAuth); string(b) != `{"token":"work"}` {
+		t.Fatalf("live config changed: %s", b)
+	}
+}
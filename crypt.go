@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// sealMagic starts every encrypted snapshot file.
+const sealMagic = "SWITCH-SEALED-1\n"
+
+// keyCacheTTL is how long a derived key stays cached after the passphrase
+// was entered.
+const keyCacheTTL = 15 * time.Minute
+
+// sessionEnv holds the session secret printed by `switch unlock`. The key
+// cache is encrypted with it, so the cache file alone cannot open snapshots.
+const sessionEnv = "SWITCH_SESSION"
+
+// kdfIterations is the PBKDF2 work factor. It is a variable so tests can
+// lower it.
+var kdfIterations = 600000
+
+// errSnapshotKey is returned when an encrypted snapshot cannot be opened with
+// the key at hand.
+var errSnapshotKey = errors.New("cannot decrypt snapshot: wrong passphrase or corrupted data")
+
+// EncryptionConfig holds what is needed to check a passphrase. The key itself
+// is never stored in the config.
+type EncryptionConfig struct {
+	Salt  string `toml:"salt"`
+	Check string `toml:"check"`
+}
+
+// keyCache is the session key cache. Key is the derived key sealed with the
+// session secret, bound to Expires.
+type keyCache struct {
+	Key     string    `json:"key"`
+	Expires time.Time `json:"expires"`
+}
+
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, kdfIterations, 32)
+}
+
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealMagic))
+}
+
+// sealData encrypts data with AES-GCM. Data that is already sealed is
+// returned unchanged, so sealing a snapshot twice is harmless.
+func sealData(key, data []byte) ([]byte, error) {
+	if isSealed(data) {
+		return data, nil
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte(sealMagic), nonce...)
+	return gcm.Seal(out, nonce, data, []byte(sealMagic)), nil
+}
+
+// openData decrypts data sealed by sealData. Plaintext is returned as is, so
+// snapshots taken before encryption was enabled keep working.
+func openData(key, data []byte) ([]byte, error) {
+	if !isSealed(data) {
+		return data, nil
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	body := data[len(sealMagic):]
+	if len(body) < gcm.NonceSize() {
+		return nil, errSnapshotKey
+	}
+	plain, err := gcm.Open(nil, body[:gcm.NonceSize()], body[gcm.NonceSize():], []byte(sealMagic))
+	if err != nil {
+		return nil, errSnapshotKey
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pathSealed reports whether path is an encrypted snapshot file, or a folder
+// holding at least one.
+func pathSealed(path string) bool {
+	if !isFolder(path) {
+		return fileSealed(path)
+	}
+	found := false
+	filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || found {
+			return filepath.SkipAll
+		}
+		if info.Mode().IsRegular() && fileSealed(p) {
+			found = true
+		}
+		return nil
+	})
+	return found
+}
+
+// pathPlain reports whether path holds any file that is not encrypted.
+func pathPlain(path string) bool {
+	found := false
+	filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || found {
+			return filepath.SkipAll
+		}
+		if info.Mode().IsRegular() && !fileSealed(p) {
+			found = true
+		}
+		return nil
+	})
+	return found
+}
+
+func fileSealed(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(sealMagic))
+	if _, err := io.ReadFull(f, head); err != nil {
+		return false
+	}
+	return isSealed(head)
+}
+
+func (s *Switcher) keyCachePath() string {
+	return filepath.Join(s.stateDir(), "keycache")
+}
+
+// checkKey reports whether key matches the configured passphrase.
+func (s *Switcher) checkKey(key []byte) bool {
+	if s.config.Encryption == nil {
+		return false
+	}
+	check, err := base64.StdEncoding.DecodeString(s.config.Encryption.Check)
+	if err != nil {
+		return false
+	}
+	plain, err := openData(key, check)
+	return err == nil && isSealed(check) && string(plain) == "switch"
+}
+
+// sessionSecret returns the session secret from SWITCH_SESSION, or nil if it
+// is not set or not valid.
+func sessionSecret() []byte {
+	secret, err := base64.RawURLEncoding.DecodeString(os.Getenv(sessionEnv))
+	if err != nil || len(secret) != 32 {
+		return nil
+	}
+	return secret
+}
+
+// cacheBinding is the additional data that ties a cached key to its expiry,
+// so the expiry cannot be pushed back without the session secret.
+func cacheBinding(expires time.Time) []byte {
+	return []byte("switch-keycache " + expires.UTC().Format(time.RFC3339Nano))
+}
+
+// readKeyCache reads the session key cache. An expired or unreadable cache
+// is deleted.
+func (s *Switcher) readKeyCache() (keyCache, bool) {
+	var cache keyCache
+	data, err := os.ReadFile(s.keyCachePath())
+	if err != nil {
+		return cache, false
+	}
+	if err := json.Unmarshal(data, &cache); err != nil || !time.Now().Before(cache.Expires) {
+		os.Remove(s.keyCachePath())
+		return cache, false
+	}
+	return cache, true
+}
+
+// pruneKeyCache deletes the session key cache once it has expired. It runs
+// on every invocation, not only when a key is needed.
+func (s *Switcher) pruneKeyCache() {
+	s.readKeyCache()
+}
+
+// cachedKey returns the encryption key if it is already known to this
+// process or held in the session key cache of the current SWITCH_SESSION.
+// It never prompts.
+func (s *Switcher) cachedKey() []byte {
+	if s.key != nil {
+		return s.key
+	}
+	cache, ok := s.readKeyCache()
+	secret := sessionSecret()
+	if !ok || secret == nil {
+		return nil
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(cache.Key)
+	if err != nil {
+		return nil
+	}
+	gcm, err := newGCM(secret)
+	if err != nil || len(wrapped) < gcm.NonceSize() {
+		return nil
+	}
+	key, err := gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], cacheBinding(cache.Expires))
+	if err != nil || !s.checkKey(key) {
+		return nil
+	}
+	s.key = key
+	return key
+}
+
+// cacheKey keeps key for the rest of this process and, when a session is
+// open, in the key cache sealed with the session secret. The key itself is
+// never written to disk.
+func (s *Switcher) cacheKey(key []byte) error {
+	s.key = key
+	secret := sessionSecret()
+	if secret == nil {
+		return nil
+	}
+	gcm, err := newGCM(secret)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	expires := time.Now().Add(keyCacheTTL).UTC()
+	wrapped := gcm.Seal(nonce, nonce, key, cacheBinding(expires))
+	if err := os.MkdirAll(s.stateDir(), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(keyCache{Key: base64.StdEncoding.EncodeToString(wrapped), Expires: expires})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.keyCachePath(), data, 0600)
+}
+
+// UnlockKeys asks for the passphrase and opens a session: it prints the
+// session secret as a shell command, for `eval "$(switch unlock)"`, and caches
+// the key sealed with it for keyCacheTTL.
+func (s *Switcher) UnlockKeys() error {
+	if sessionSecret() == nil {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		os.Setenv(sessionEnv, base64.RawURLEncoding.EncodeToString(secret))
+	}
+	s.key = nil
+	if _, err := s.encryptionKey(); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		fmt.Printf("$env:%s = \"%s\"\n", sessionEnv, os.Getenv(sessionEnv))
+	} else {
+		fmt.Printf("export %s=%s\n", sessionEnv, os.Getenv(sessionEnv))
+	}
+	fmt.Fprintf(os.Stderr, "%s✓ Unlocked for %s; set %s as shown to use the cached key%s\n",
+		ColorGreen, keyCacheTTL, sessionEnv, ColorReset)
+	return nil
+}
+
+// encryptionKey returns the key for encrypted snapshots, asking for the
+// passphrase unless it is cached or set in SWITCH_PASSPHRASE.
+func (s *Switcher) encryptionKey() ([]byte, error) {
+	if key := s.cachedKey(); key != nil {
+		return key, nil
+	}
+	if s.config.Encryption == nil {
+		return nil, fmt.Errorf("encryption is not set up; run 'switch encrypt <app>' first")
+	}
+	salt, err := base64.StdEncoding.DecodeString(s.config.Encryption.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption salt in config: %w", err)
+	}
+	passphrase, err := readPassphrase("Passphrase")
+	if err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if !s.checkKey(key) {
+		return nil, fmt.Errorf("wrong passphrase")
+	}
+	if err := s.cacheKey(key); err != nil {
+		fmt.Fprintf(os.Stderr, "%s! Could not cache encryption key: %v%s\n", ColorYellow, err, ColorReset)
+	}
+	return key, nil
+}
+
+// setupEncryption returns the encryption key, choosing a new passphrase if
+// encryption has not been set up yet.
+func (s *Switcher) setupEncryption() ([]byte, error) {
+	if s.config.Encryption != nil {
+		return s.encryptionKey()
+	}
+	passphrase, err := readPassphrase("New passphrase")
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+	if os.Getenv("SWITCH_PASSPHRASE") == "" {
+		confirm, err := readPassphrase("Repeat passphrase")
+		if err != nil {
+			return nil, err
+		}
+		if confirm != passphrase {
+			return nil, fmt.Errorf("passphrases do not match")
+		}
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	check, err := sealData(key, []byte("switch"))
+	if err != nil {
+		return nil, err
+	}
+	s.config.Encryption = &EncryptionConfig{
+		Salt:  base64.StdEncoding.EncodeToString(salt),
+		Check: base64.StdEncoding.EncodeToString(check),
+	}
+	if err := s.saveConfig(); err != nil {
+		return nil, err
+	}
+	if err := s.cacheKey(key); err != nil {
+		fmt.Fprintf(os.Stderr, "%s! Could not cache encryption key: %v%s\n", ColorYellow, err, ColorReset)
+	}
+	return key, nil
+}
+
+// readPassphrase takes the passphrase from SWITCH_PASSPHRASE or asks for it
+// with terminal echo turned off.
+func readPassphrase(label string) (string, error) {
+	if p := os.Getenv("SWITCH_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	// Prompt on stderr so `eval "$(switch unlock)"` only sees the session.
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 && runtime.GOOS != "windows" {
+		if stty("-echo") == nil {
+			defer func() {
+				stty("echo")
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+	input, err := stdinReader.ReadString('\n')
+	if err != nil && input == "" {
+		return "", fmt.Errorf("no passphrase given")
+	}
+	return strings.TrimRight(input, "\r\n"), nil
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// sealTransform returns the transform used to write snapshots of appConfig:
+// encryption for apps with encrypt set, nil otherwise.
+func (s *Switcher) sealTransform(appConfig AppConfig) (fileTransform, error) {
+	if !appConfig.Encrypt {
+		return nil, nil
+	}
+	key, err := s.setupEncryption()
+	if err != nil {
+		return nil, err
+	}
+	return func(data []byte) ([]byte, error) { return sealData(key, data) }, nil
+}
+
+// openTransform returns the transform used to restore src: decryption if it
+// holds encrypted files, nil otherwise.
+func (s *Switcher) openTransform(src string) (fileTransform, error) {
+	if !pathSealed(src) {
+		return nil, nil
+	}
+	key, err := s.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	return func(data []byte) ([]byte, error) { return openData(key, data) }, nil
+}
+
+// EncryptSnapshots turns on encryption for an app and encrypts its existing
+// plaintext snapshots in place.
+func (s *Switcher) EncryptSnapshots(appName string) error {
+	appConfig, err := s.resolveApp(appName)
+	if err != nil {
+		return err
+	}
+	if err := s.checkSchemaVersion(); err != nil {
+		return err
+	}
+	key, err := s.setupEncryption()
+	if err != nil {
+		return err
+	}
+	seal := func(data []byte) ([]byte, error) { return sealData(key, data) }
+
+	encrypted := 0
+	authPath := expandPath(appConfig.AuthPath)
+	for _, acc := range appConfig.Accounts {
+		switchPath := resolveSwitchPattern(appConfig.SwitchPattern, appName, authPath, acc)
+		if !fileOrDirExists(switchPath) || !pathPlain(switchPath) {
+			continue
+		}
+		if err := replacePathWith(switchPath, switchPath, copyOptions{transform: seal}); err != nil {
+			return fmt.Errorf("encrypt %s: %w", acc, err)
+		}
+		encrypted++
+	}
+
+	appConfig.Encrypt = true
+	s.SetAppConfig(appName, appConfig)
+	if err := s.saveConfig(); err != nil {
+		return err
+	}
+	fmt.Printf("%s✓ Encryption enabled for %s (%d snapshot(s) encrypted)%s\n", ColorGreen, appName, encrypted, ColorReset)
+	return nil
+}
+
+// LockKeys forgets the cached encryption key, so the passphrase is asked for
+// again on the next switch.
+func (s *Switcher) LockKeys() error {
+	s.key = nil
+	if err := os.Remove(s.keyCachePath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fmt.Printf("%s✓ Encryption key cache cleared%s\n", ColorGreen, ColorReset)
+	return nil
+}
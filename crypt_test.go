@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fastKDF(t *testing.T) {
+	t.Helper()
+	old := kdfIterations
+	kdfIterations = 1000
+	t.Cleanup(func() { kdfIterations = old })
+}
+
+func TestSealOpenData(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	sealed, err := sealData(key, []byte(`{"token":"secret"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSealed(sealed) || bytes.Contains(sealed, []byte("secret")) {
+		t.Fatalf("data not sealed: %q", sealed)
+	}
+	again, _ := sealData(key, sealed)
+	if !bytes.Equal(again, sealed) {
+		t.Fatalf("sealing twice should be a no-op")
+	}
+	plain, err := openData(key, sealed)
+	if err != nil || string(plain) != `{"token":"secret"}` {
+		t.Fatalf("open failed: %q %v", plain, err)
+	}
+	if _, err := openData(bytes.Repeat([]byte{8}, 32), sealed); err != errSnapshotKey {
+		t.Fatalf("expected wrong key error, got %v", err)
+	}
+	if plain, err := openData(key, []byte("plain")); err != nil || string(plain) != "plain" {
+		t.Fatalf("plaintext should pass through: %q %v", plain, err)
+	}
+}
+
+func TestEncryptedProfiles_AddAndSwitch(t *testing.T) {
+	fastKDF(t)
+	t.Setenv("SWITCH_PASSPHRASE", "hunter2")
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"work"}`, map[string]string{})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Accounts: []string{}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch", Encrypt: true})
+
+	if err := s.AddAccount("codex", "work"); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(authPath, []byte(`{"token":"home"}`), 0600)
+	if err := s.AddAccount("codex", "home"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"work", "home"} {
+		data, _ := os.ReadFile(authPath + "." + name + ".switch")
+		if !isSealed(data) || bytes.Contains(data, []byte("token")) {
+			t.Fatalf("snapshot %s stored in plaintext: %q", name, data)
+		}
+	}
+	if s.config.Encryption == nil {
+		t.Fatalf("encryption settings not saved")
+	}
+	if cur := s.findCurrentAccount("codex"); cur != "home" {
+		t.Fatalf("expected current home, got %q", cur)
+	}
+
+	if err := s.SwitchAccount("codex", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(authPath); string(got) != `{"token":"work"}` {
+		t.Fatalf("live config not decrypted: %q", got)
+	}
+	entries, _ := s.loadHistory()
+	if undo, _ := os.ReadFile(entries[0].Undo); !isSealed(undo) {
+		t.Fatalf("undo copy stored in plaintext: %q", undo)
+	}
+	if err := s.Undo("codex"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(authPath); string(got) != `{"token":"home"}` {
+		t.Fatalf("undo did not restore decrypted content: %q", got)
+	}
+}
+
+func TestEncryptSnapshots_InPlaceAndLock(t *testing.T) {
+	fastKDF(t)
+	t.Setenv("SWITCH_PASSPHRASE", "hunter2")
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+
+	if code := handleEncrypt(s, []string{"codex"}); code != 0 {
+		t.Fatalf("encrypt failed: %d", code)
+	}
+	for _, name := range []string{"a", "b"} {
+		if !fileSealed(authPath + "." + name + ".switch") {
+			t.Fatalf("snapshot %s not encrypted", name)
+		}
+	}
+	if app, _ := s.GetAppConfig("codex"); !app.Encrypt {
+		t.Fatalf("encrypt flag not set")
+	}
+	// Without a session the key is not written to disk
+	if fileOrDirExists(s.keyCachePath()) {
+		t.Fatalf("key cached without a session")
+	}
+
+	t.Setenv("SWITCH_SESSION", "")
+	out, _ := captureOutput(t, func() {
+		if err := s.UnlockKeys(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	session := strings.TrimPrefix(strings.TrimSpace(out), "export SWITCH_SESSION=")
+	if session == "" || session == strings.TrimSpace(out) {
+		t.Fatalf("session not printed: %q", out)
+	}
+	data, _ := os.ReadFile(s.keyCachePath())
+	if len(data) == 0 || bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString(s.key))) {
+		t.Fatalf("raw key stored in cache: %q", data)
+	}
+
+	// A new process reuses the cached key only with the session secret
+	t.Setenv("SWITCH_PASSPHRASE", "")
+	t.Setenv("SWITCH_SESSION", "")
+	s2, _ := newTestSwitcher(t, home)
+	if key := s2.cachedKey(); key != nil {
+		t.Fatalf("cached key opened without the session")
+	}
+	t.Setenv("SWITCH_SESSION", session)
+	if cur := s2.findCurrentAccount("codex"); cur != "a" {
+		t.Fatalf("expected a with cached key, got %q", cur)
+	}
+	// Snapshots are compared in memory
+	if tmp, _ := filepath.Glob(filepath.Join(s2.stateDir(), "compare-*")); len(tmp) != 0 {
+		t.Fatalf("decrypted copies written to disk: %v", tmp)
+	}
+	if st := s2.appStatus("codex"); st.Locked {
+		t.Fatalf("status locked with cached key")
+	}
+
+	if err := s2.LockKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if fileOrDirExists(s.keyCachePath()) {
+		t.Fatalf("key cache not removed")
+	}
+	// While locked the recorded current profile is trusted, and list says so
+	s3, _ := newTestSwitcher(t, home)
+	if cur := s3.findCurrentAccount("codex"); cur != "a" {
+		t.Fatalf("expected recorded current a while locked, got %q", cur)
+	}
+	out, _ = captureOutput(t, func() { s3.ListAccounts("codex") })
+	if !strings.Contains(out, "(current, locked)") || !strings.Contains(out, "switch unlock") {
+		t.Fatalf("locked state not shown: %q", out)
+	}
+	if st := s3.appStatus("codex"); !st.Locked || st.Current != "a" {
+		t.Fatalf("status not marked locked: %+v", st)
+	}
+
+	t.Setenv("SWITCH_PASSPHRASE", "wrong")
+	if err := s3.SwitchAccount("codex", "b"); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Fatalf("expected wrong passphrase error, got %v", err)
+	}
+	if got, _ := os.ReadFile(authPath); string(got) != `{"token":"a"}` {
+		t.Fatalf("live config changed: %q", got)
+	}
+}
+
+func TestEncryptedProfiles_FolderApp(t *testing.T) {
+	fastKDF(t)
+	t.Setenv("SWITCH_PASSPHRASE", "hunter2")
+	home := setHome(t)
+	live := filepath.Join(home, ".ssh")
+	os.MkdirAll(live, 0700)
+	os.WriteFile(filepath.Join(live, "id_work"), []byte("work-key"), 0600)
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("ssh", AppConfig{Accounts: []string{}, AuthPath: "~/.ssh", SwitchPattern: "~/.ssh-profiles/{name}.switch", Encrypt: true})
+	if err := s.AddAccount("ssh", "work"); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(live, "id_work"))
+	os.WriteFile(filepath.Join(live, "id_home"), []byte("home-key"), 0600)
+	if err := s.AddAccount("ssh", "home"); err != nil {
+		t.Fatal(err)
+	}
+	stored := filepath.Join(home, ".ssh-profiles", "work.switch", "id_work")
+	if !fileSealed(stored) {
+		t.Fatalf("folder snapshot file not encrypted")
+	}
+	if err := s.SwitchAccount("ssh", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(live, "id_work")); string(got) != "work-key" {
+		t.Fatalf("folder not decrypted: %q", got)
+	}
+	if fileOrDirExists(filepath.Join(live, "id_home")) {
+		t.Fatalf("stale file left behind")
+	}
+	if cur := s.findCurrentAccount("ssh"); cur != "work" {
+		t.Fatalf("expected current work, got %q", cur)
+	}
+}
+
+func TestCachedKey_Expired(t *testing.T) {
+	home := setHome(t)
+	s, _ := newTestSwitcher(t, home)
+	os.MkdirAll(s.stateDir(), 0700)
+	data, _ := json.Marshal(keyCache{Key: "AAAA", Expires: time.Now().Add(-time.Minute)})
+	os.WriteFile(s.keyCachePath(), data, 0600)
+	if key := s.cachedKey(); key != nil {
+		t.Fatalf("expired key returned")
+	}
+	if fileOrDirExists(s.keyCachePath()) {
+		t.Fatalf("expired key cache not removed")
+	}
+	// Every invocation prunes an expired cache, even without a session
+	os.WriteFile(s.keyCachePath(), data, 0600)
+	s.pruneKeyCache()
+	if fileOrDirExists(s.keyCachePath()) {
+		t.Fatalf("expired key cache not pruned on start")
+	}
+	if _, err := s.encryptionKey(); err == nil || !strings.Contains(err.Error(), "not set up") {
+		t.Fatalf("expected not set up error, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkDrift reports whether the live config of appName holds changes that
+// are not stored in any profile. current is the result of findCurrentAccount.
+// origin is the profile the live config was last loaded from, if known.
+func (s *Switcher) checkDrift(appName, current string) (drifted bool, origin string) {
+	appConfig, exists := s.GetAppConfig(appName)
+	if !exists || current != "" || len(appConfig.Accounts) == 0 || validateEnv(appConfig) != nil {
+		return false, ""
+	}
+	if !liveExists(appConfig) {
+		return false, ""
+	}
+	if contains(appConfig.Accounts, appConfig.Current) {
+		origin = appConfig.Current
+	}
+	return true, origin
+}
+
+// resolveDrift asks what to do with unsaved changes in the live config before
+// a switch overwrites them. When no answer can be read, for example because
+// stdin is not interactive, the changes are stashed so nothing is lost.
+func (s *Switcher) resolveDrift(appName, origin string) error {
+	appConfig, _ := s.GetAppConfig(appName)
+	authPath := expandPath(appConfig.AuthPath)
+
+	if origin != "" {
+		fmt.Printf("%s! Live %s config was modified since it was loaded from profile %s%s\n", ColorYellow, appName, origin, ColorReset)
+	} else {
+		fmt.Printf("%s! Live %s config does not match any saved profile%s\n", ColorYellow, appName, ColorReset)
+	}
+	saveLabel := "Save changes into " + origin + " and switch"
+	if origin == "" {
+		saveLabel = "Save as a new profile and switch"
+	}
+	idx, err := promptChoice("Unsaved changes:", []string{saveLabel, "Discard changes and switch", "Stash a copy and switch"})
+	if err != nil {
+		fmt.Printf("\n%sNo answer, stashing the live config%s\n", ColorYellow, ColorReset)
+		idx = 2
+	}
+
+	switch idx {
+	case 0:
+		if origin == "" {
+			name, err := promptString("Profile name", "")
+			if err != nil {
+				return err
+			}
+			if name == "" {
+				fmt.Printf("%sCancelled%s\n", ColorYellow, ColorReset)
+				return fmt.Errorf("cancelled by user")
+			}
+			return s.AddAccount(appName, name)
+		}
+		switchPath := resolveSwitchPattern(appConfig.SwitchPattern, appName, authPath, origin)
+		if err := s.writeSnapshot(appConfig, switchPath); err != nil {
+			return fmt.Errorf("save changes: %w", err)
+		}
+		fmt.Printf("%s✓ Saved changes to profile %s%s\n", ColorGreen, origin, ColorReset)
+	case 1:
+		fmt.Printf("%sDiscarding changes to the live %s config%s\n", ColorYellow, appName, ColorReset)
+	case 2:
+		stash, err := s.stashLive(appName)
+		if err != nil {
+			return fmt.Errorf("stash changes: %w", err)
+		}
+		fmt.Printf("%s✓ Stashed live config to %s%s\n", ColorGreen, stash, ColorReset)
+	default:
+		fmt.Printf("%sCancelled%s\n", ColorYellow, ColorReset)
+		return fmt.Errorf("cancelled by user")
+	}
+	return nil
+}
+
+// stashLive copies the live config into a timestamped folder under the state
+// directory and returns the path of the copy.
+func (s *Switcher) stashLive(appName string) (string, error) {
+	dir := filepath.Join(s.stateDir(), "stash", appName, time.Now().Format("20060102-150405.000"))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	appConfig, _ := s.GetAppConfig(appName)
+	dst := filepath.Join(dir, liveBase(appConfig))
+	if err := s.copyOut(appConfig, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setupDriftedCodex(t *testing.T) (*Switcher, string, string) {
+	t.Helper()
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a-refreshed"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	if err := s.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	return s, home, authPath
+}
+
+func TestCheckDrift(t *testing.T) {
+	s, _, authPath := setupDriftedCodex(t)
+	if drifted, origin := s.checkDrift("codex", s.findCurrentAccount("codex")); !drifted || origin != "a" {
+		t.Fatalf("expected drift from a, got %v %q", drifted, origin)
+	}
+	os.WriteFile(authPath, []byte(`{"token":"a"}`), 0600)
+	if drifted, _ := s.checkDrift("codex", s.findCurrentAccount("codex")); drifted {
+		t.Fatalf("no drift expected when live matches a profile")
+	}
+	os.Remove(authPath)
+	if drifted, _ := s.checkDrift("codex", ""); drifted {
+		t.Fatalf("no drift expected without a live config")
+	}
+	if drifted, _ := s.checkDrift("nosuch", ""); drifted {
+		t.Fatalf("no drift expected for unknown app")
+	}
+}
+
+func TestListAccounts_ShowsDirtyMarker(t *testing.T) {
+	s, _, _ := setupDriftedCodex(t)
+	out, _ := captureOutput(t, func() { s.ListAccounts("codex") })
+	if !strings.Contains(out, "a "+ColorRed+"(current, modified)") {
+		t.Fatalf("expected modified marker on a: %q", out)
+	}
+	s.config.Default.Config = "codex"
+	out2, _ := captureOutput(t, func() { s.ListAllApps() })
+	if !strings.Contains(out2, "current: a") || !strings.Contains(out2, "(modified)") {
+		t.Fatalf("expected modified marker in app list: %q", out2)
+	}
+	// Unknown origin
+	s.SetAppConfig("codex", AppConfig{Current: "", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	out3, _ := captureOutput(t, func() { s.ListAccounts("codex") })
+	if !strings.Contains(out3, "unsaved changes") {
+		t.Fatalf("expected unsaved changes note: %q", out3)
+	}
+}
+
+func TestSwitchAccount_DriftSave(t *testing.T) {
+	s, _, authPath := setupDriftedCodex(t)
+	withStdin(t, "1\n", func() {
+		if err := s.SwitchAccount("codex", "b"); err != nil {
+			t.Fatalf("SwitchAccount: %v", err)
+		}
+	})
+	if b, _ := os.ReadFile(authPath + ".a.switch"); string(b) != `{"token":"a-refreshed"}` {
+		t.Fatalf("changes not saved into a: %s", string(b))
+	}
+	if b, _ := os.ReadFile(authPath); string(b) != `{"token":"b"}` {
+		t.Fatalf("not switched to b: %s", string(b))
+	}
+}
+
+func TestSwitchAccount_DriftDiscard(t *testing.T) {
+	s, _, authPath := setupDriftedCodex(t)
+	withStdin(t, "2\n", func() {
+		if err := s.SwitchAccount("codex", "b"); err != nil {
+			t.Fatalf("SwitchAccount: %v", err)
+		}
+	})
+	if b, _ := os.ReadFile(authPath + ".a.switch"); string(b) != `{"token":"a"}` {
+		t.Fatalf("profile a should be untouched on discard: %s", string(b))
+	}
+	if b, _ := os.ReadFile(authPath); string(b) != `{"token":"b"}` {
+		t.Fatalf("not switched to b: %s", string(b))
+	}
+}
+
+func TestSwitchAccount_DriftStashAndCancel(t *testing.T) {
+	s, home, authPath := setupDriftedCodex(t)
+	// Cancel leaves everything in place
+	withStdin(t, "\n", func() {
+		if err := s.SwitchAccount("codex", "b"); err == nil || !strings.Contains(err.Error(), "cancelled") {
+			t.Fatalf("expected cancel, got %v", err)
+		}
+	})
+	if b, _ := os.ReadFile(authPath); string(b) != `{"token":"a-refreshed"}` {
+		t.Fatalf("live config changed on cancel: %s", string(b))
+	}
+	// No answer available stashes the live config
+	withStdin(t, "", func() {
+		if err := s.SwitchAccount("codex", "b"); err != nil {
+			t.Fatalf("SwitchAccount: %v", err)
+		}
+	})
+	stashes, _ := filepath.Glob(filepath.Join(home, ".switch", "stash", "codex", "*", "auth.json"))
+	if len(stashes) != 1 {
+		t.Fatalf("expected one stash, got %v", stashes)
+	}
+	if b, _ := os.ReadFile(stashes[0]); string(b) != `{"token":"a-refreshed"}` {
+		t.Fatalf("stash content wrong: %s", string(b))
+	}
+}
+
+func TestSwitchAccount_DriftSaveAsNewProfile(t *testing.T) {
+	s, _, authPath := setupDriftedCodex(t)
+	s.SetAppConfig("codex", AppConfig{Current: "", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	withStdin(t, "1\nc\n", func() {
+		if err := s.SwitchAccount("codex", "b"); err != nil {
+			t.Fatalf("SwitchAccount: %v", err)
+		}
+	})
+	if b, _ := os.ReadFile(authPath + ".c.switch"); string(b) != `{"token":"a-refreshed"}` {
+		t.Fatalf("new profile c not saved: %s", string(b))
+	}
+	app, _ := s.GetAppConfig("codex")
+	if !contains(app.Accounts, "c") || app.Current != "b" {
+		t.Fatalf("config not updated: %+v", app)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+// decodeJSON parses a complete JSON document of any kind, keeping numbers
+// exact.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+// valuesEqual compares decoded values semantically: object key order does
+// not matter and numbers are compared by value, so 1, 1.0 and 1e0 are
+// equal.
+func valuesEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			other, ok := bv[k]
+			if !ok || !valuesEqual(v, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !valuesEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		bv, ok := b.(json.Number)
+		return ok && numbersEqual(av, bv)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func numbersEqual(a, b json.Number) bool {
+	if a == b {
+		return true
+	}
+	x, okA := new(big.Rat).SetString(string(a))
+	y, okB := new(big.Rat).SetString(string(b))
+	return okA && okB && x.Cmp(y) == 0
+}
+
+// stripIgnored removes ignored keys from a decoded document in place and
+// returns it. Rules starting with / are JSON pointers from the root; other
+// rules remove a key of that name at any depth.
+func stripIgnored(v interface{}, ignore []string) interface{} {
+	for _, rule := range ignore {
+		if strings.HasPrefix(rule, "/") {
+			doc, ok := v.(map[string]interface{})
+			if tokens, err := parsePointer(rule); ok && err == nil {
+				deleteKey(doc, tokens)
+			}
+			continue
+		}
+		dropKey(v, rule)
+	}
+	return v
+}
+
+func dropKey(v interface{}, name string) {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		delete(vv, name)
+		for _, child := range vv {
+			dropKey(child, name)
+		}
+	case []interface{}:
+		for _, child := range vv {
+			dropKey(child, name)
+		}
+	}
+}
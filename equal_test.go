@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValuesEqual(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		equal bool
+	}{
+		{`[1, 2, {"a": 1}]`, `[1.0, 2e0, {"a": 1.00}]`, true},
+		{`[1, 2]`, `[2, 1]`, false},
+		{`"x"`, `"x"`, true},
+		{`42`, `42.0`, true},
+		{`12345678901234567890`, `12345678901234567891`, false},
+		{`{"a": null}`, `{}`, false},
+		{`true`, `"true"`, false},
+	}
+	for _, c := range cases {
+		a, err := decodeJSON([]byte(c.a))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := decodeJSON([]byte(c.b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := valuesEqual(a, b); got != c.equal {
+			t.Errorf("%s vs %s: got %v, want %v", c.a, c.b, got, c.equal)
+		}
+	}
+	if _, err := decodeJSON([]byte(`{} {}`)); err == nil {
+		t.Errorf("expected error for trailing data")
+	}
+}
+
+func TestFileEqual_NonObjectRoot(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.json")
+	b := filepath.Join(dir, "b.json")
+	os.WriteFile(a, []byte(`[{"id": 1, "name": "x"}]`), 0644)
+	os.WriteFile(b, []byte("[\n  {\"name\": \"x\", \"id\": 1.0}\n]\n"), 0644)
+	if !fileEqual(a, b) {
+		t.Fatalf("expected array documents to be equal")
+	}
+}
+
+func TestFileEqual_IgnoreKeys(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.json")
+	b := filepath.Join(dir, "b.json")
+	os.WriteFile(a, []byte(`{"token":"t","last_refresh":"1","meta":{"updated":"1","id":1}}`), 0644)
+	os.WriteFile(b, []byte(`{"token":"t","last_refresh":"2","meta":{"updated":"2","id":1}}`), 0644)
+	if fileEqual(a, b) {
+		t.Fatalf("expected files to differ without ignore rules")
+	}
+	filter := pathFilter{ignore: []string{"last_refresh", "/meta/updated"}}
+	if !fileEqualWith(a, b, filter, nil) {
+		t.Fatalf("expected files to be equal with ignore rules")
+	}
+	os.WriteFile(b, []byte(`{"token":"u","last_refresh":"2","meta":{"updated":"2","id":1}}`), 0644)
+	if fileEqualWith(a, b, filter, nil) {
+		t.Fatalf("expected a changed token to be noticed")
+	}
+}
+
+func TestFindCurrentAccount_IgnoresRefreshTimestamp(t *testing.T) {
+	home := setHome(t)
+	auth := filepath.Join(home, ".codex", "auth.json")
+	os.MkdirAll(filepath.Dir(auth), 0755)
+	os.WriteFile(auth, []byte(`{"token":"work","last_refresh":"2026-01-01T00:00:00Z"}`), 0600)
+
+	s, _ := newTestSwitcher(t, home)
+	if err := s.AddAccount("codex", "work"); err != nil {
+		t.Fatal(err)
+	}
+	appConfig, _ := s.GetAppConfig("codex")
+	if len(appConfig.IgnoreKeys) == 0 {
+		t.Fatalf("expected codex template to ignore last_refresh")
+	}
+	os.WriteFile(auth, []byte(`{"last_refresh":"2026-02-01T00:00:00Z","token":"work"}`), 0600)
+	if cur := s.findCurrentAccount("codex"); cur != "work" {
+		t.Fatalf("expected current work after refresh, got %q", cur)
+	}
+}
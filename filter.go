@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pathFilter decides which entries of a folder belong to a profile. Paths
+// are slash-separated and relative to the folder root. Entries that are not
+// managed are left out of snapshots and comparisons and are carried over
+// unchanged when the folder is switched. The zero value manages everything.
+type pathFilter struct {
+	// stores lists profile stores that live inside the folder.
+	stores []string
+	// include and exclude are globs anchored at the folder root, where **
+	// matches any number of folders. A matching folder covers everything
+	// below it. An empty include list includes everything.
+	include []string
+	exclude []string
+	// keys limits files in format to the values at these JSON pointers.
+	keys   []string
+	format string
+	// ignore lists keys left out when comparing JSON files.
+	ignore []string
+}
+
+// managed reports whether the entry at rel is part of a profile.
+func (f pathFilter) managed(rel string) bool {
+	if f.excluded(rel) {
+		return false
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for p := rel; p != "."; p = path.Dir(p) {
+		if matchAnyGlob(f.include, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// visit reports whether a walk should descend into the folder at rel,
+// because it is managed or may hold managed entries.
+func (f pathFilter) visit(rel string) bool {
+	if f.excluded(rel) {
+		return false
+	}
+	if f.managed(rel) {
+		return true
+	}
+	segs := strings.Split(rel, "/")
+	for _, pattern := range f.include {
+		if globPrefix(globSegments(pattern), segs) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f pathFilter) excluded(rel string) bool {
+	for _, store := range f.stores {
+		if rel == store || strings.HasPrefix(rel, store+"/") {
+			return true
+		}
+	}
+	for p := rel; p != "."; p = path.Dir(p) {
+		if matchAnyGlob(f.exclude, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// skip reports whether a walk should pass over the entry at rel: a file
+// that is not managed, or a folder that holds nothing managed.
+func (f pathFilter) skip(rel string, info os.FileInfo) bool {
+	if info.IsDir() {
+		return !f.visit(rel)
+	}
+	return !f.managed(rel)
+}
+
+func globSegments(pattern string) []string {
+	pattern = strings.TrimPrefix(strings.TrimPrefix(pattern, "./"), "/")
+	return strings.Split(strings.TrimSuffix(pattern, "/"), "/")
+}
+
+func matchAnyGlob(patterns []string, rel string) bool {
+	segs := strings.Split(rel, "/")
+	for _, pattern := range patterns {
+		if matchGlob(globSegments(pattern), segs) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGlob(pattern, segs []string) bool {
+	if len(pattern) == 0 {
+		return len(segs) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if matchGlob(pattern[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+		return false
+	}
+	return matchGlob(pattern[1:], segs[1:])
+}
+
+// globPrefix reports whether segs could be a folder above a path matched
+// by pattern.
+func globPrefix(pattern, segs []string) bool {
+	if len(segs) == 0 {
+		return len(pattern) > 0
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+	if pattern[0] == "**" {
+		return true
+	}
+	if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+		return false
+	}
+	return globPrefix(pattern[1:], segs[1:])
+}
+
+// validateGlobs reports the first malformed include or exclude pattern.
+func validateGlobs(appConfig AppConfig) error {
+	for _, pattern := range append(append([]string{}, appConfig.Include...), appConfig.Exclude...) {
+		for _, seg := range globSegments(pattern) {
+			if _, err := path.Match(seg, ""); err != nil {
+				return fmt.Errorf("invalid glob %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// unmanaged lists the outermost entries below root that are not managed.
+func (f pathFilter) unmanaged(root string) []string {
+	if !isFolder(root) {
+		return nil
+	}
+	var out []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if !f.visit(rel) {
+				out = append(out, rel)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !f.managed(rel) {
+			out = append(out, rel)
+		}
+		return nil
+	})
+	sort.Strings(out)
+	return out
+}
+
+// relWithin returns path relative to root in slash form if path lies inside
+// root.
+func relWithin(root, path string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// profileFilter returns the filter that selects the profile content of an
+// app's live config at authPath.
+func profileFilter(appConfig AppConfig, authPath string) pathFilter {
+	return pathFilter{
+		stores:  nestedStorePaths(appConfig, authPath),
+		include: appConfig.Include,
+		exclude: appConfig.Exclude,
+		keys:    appConfig.Keys,
+		format:  configFormat(appConfig),
+		ignore:  appConfig.IgnoreKeys,
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPathFilter(t *testing.T) {
+	f := pathFilter{stores: []string{"profiles"}}
+	for rel, want := range map[string]bool{"config": true, "profiles": false, "profiles/a.switch/config": false, "profiles2": true} {
+		if got := f.managed(rel); got != want {
+			t.Errorf("managed(%q) = %v, want %v", rel, got, want)
+		}
+	}
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "profiles", "a.switch"), 0755)
+	os.WriteFile(filepath.Join(root, "config"), []byte("x"), 0600)
+	if got := f.unmanaged(root); len(got) != 1 || got[0] != "profiles" {
+		t.Fatalf("unexpected unmanaged entries: %v", got)
+	}
+	if got := (pathFilter{}).unmanaged(root); len(got) != 0 {
+		t.Fatalf("zero filter should manage everything: %v", got)
+	}
+}
+
+func TestNestedStore_ExcludedFromSnapshots(t *testing.T) {
+	home := setHome(t)
+	ssh := filepath.Join(home, ".ssh")
+	os.MkdirAll(ssh, 0700)
+	os.WriteFile(filepath.Join(ssh, "config"), []byte("Host work"), 0600)
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("ssh", AppConfig{Accounts: []string{}, AuthPath: "~/.ssh", SwitchPattern: "~/.ssh/profiles/{name}.switch"})
+
+	if err := s.AddAccount("ssh", "work"); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(ssh, "config"), []byte("Host home"), 0600)
+	if err := s.AddAccount("ssh", "home"); err != nil {
+		t.Fatal(err)
+	}
+	home1 := filepath.Join(ssh, "profiles", "home.switch")
+	if fileOrDirExists(filepath.Join(home1, "profiles")) {
+		t.Fatalf("snapshot contains the profile store")
+	}
+	if cur := s.findCurrentAccount("ssh"); cur != "home" {
+		t.Fatalf("expected current home despite the store in the live folder, got %q", cur)
+	}
+
+	if err := s.SwitchAccount("ssh", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(ssh, "config")); string(b) != "Host work" {
+		t.Fatalf("switch failed: %q", b)
+	}
+	for _, name := range []string{"work", "home"} {
+		if !fileOrDirExists(filepath.Join(ssh, "profiles", name+".switch", "config")) {
+			t.Fatalf("profile %s lost by the switch", name)
+		}
+	}
+	if cur := s.findCurrentAccount("ssh"); cur != "work" {
+		t.Fatalf("expected current work, got %q", cur)
+	}
+
+	// A snapshot taken before stores were excluded does not bring the
+	// stale nested copy back into the live folder.
+	legacy := filepath.Join(home1, "profiles", "old.switch")
+	os.MkdirAll(legacy, 0700)
+	os.WriteFile(filepath.Join(legacy, "config"), []byte("stale"), 0600)
+	if err := s.SwitchAccount("ssh", "home"); err != nil {
+		t.Fatal(err)
+	}
+	if fileOrDirExists(filepath.Join(ssh, "profiles", "old.switch")) {
+		t.Fatalf("nested store from an old snapshot restored into the live folder")
+	}
+	if !fileOrDirExists(filepath.Join(ssh, "profiles", "work.switch", "config")) {
+		t.Fatalf("live profile store replaced")
+	}
+}
+
+func TestValidateSwitchPattern(t *testing.T) {
+	home := setHome(t)
+	os.MkdirAll(filepath.Join(home, "cfg", "sub"), 0755)
+	os.WriteFile(filepath.Join(home, "file.json"), []byte("{}"), 0600)
+	cases := []struct {
+		auth, pattern, want string
+	}{
+		{"~/cfg/sub", "{auth_path}", "config path itself"},
+		{"~/cfg/sub", "~/cfg/{name}/..", "would contain"},
+		{"~/cfg/sub", "~/cfg", "would contain"},
+		{"~/file.json", "{auth_path}/{name}", "inside the file"},
+	}
+	for _, c := range cases {
+		err := validateSwitchPattern(AppConfig{AuthPath: c.auth, SwitchPattern: c.pattern})
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s with %s: expected %q error, got %v", c.auth, c.pattern, c.want, err)
+		}
+	}
+	for _, pattern := range []string{"{auth_path}.{name}.switch", "~/cfg/sub/profiles/{name}", "~/.local/share/switch/x/{name}"} {
+		if err := validateSwitchPattern(AppConfig{AuthPath: "~/cfg/sub", SwitchPattern: pattern}); err != nil {
+			t.Errorf("pattern %s rejected: %v", pattern, err)
+		}
+	}
+
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("cfg", AppConfig{Accounts: []string{}, AuthPath: "~/cfg/sub", SwitchPattern: "~/cfg"})
+	if err := s.AddAccount("cfg", "a"); err == nil {
+		t.Fatalf("AddAccount accepted a recursive pattern")
+	}
+}
+
+func TestGlobMatching(t *testing.T) {
+	cases := []struct {
+		pattern, rel string
+		want         bool
+	}{
+		{"settings.json", "settings.json", true},
+		{"settings.json", "sub/settings.json", false},
+		{"snippets/", "snippets", true},
+		{"**/*.log", "a/b/c.log", true},
+		{"**/*.log", "c.log", true},
+		{"a/**/z", "a/z", true},
+		{"a/**/z", "a/b/c/z", true},
+		{"a/*/z", "a/b/c/z", false},
+	}
+	for _, c := range cases {
+		if got := matchAnyGlob([]string{c.pattern}, c.rel); got != c.want {
+			t.Errorf("match(%q, %q) = %v, want %v", c.pattern, c.rel, got, c.want)
+		}
+	}
+
+	f := pathFilter{include: []string{"settings.json", "snippets/", "**/*.code-snippets"}, exclude: []string{"snippets/tmp"}}
+	for rel, want := range map[string]bool{
+		"settings.json":         true,
+		"snippets/go.json":      true,
+		"snippets/tmp/x":        false,
+		"workspaceStorage/a.db": false,
+		"a/b.code-snippets":     true,
+	} {
+		if got := f.managed(rel); got != want {
+			t.Errorf("managed(%q) = %v, want %v", rel, got, want)
+		}
+	}
+	for rel, want := range map[string]bool{"snippets": true, "workspaceStorage": true, "snippets/tmp": false} {
+		if got := f.visit(rel); got != want {
+			t.Errorf("visit(%q) = %v, want %v", rel, got, want)
+		}
+	}
+	if (pathFilter{include: []string{"settings.json"}}).visit("History") {
+		t.Errorf("folders that cannot hold included entries should not be visited")
+	}
+	if err := validateGlobs(AppConfig{Exclude: []string{"[bad"}}); err == nil {
+		t.Errorf("expected malformed glob error")
+	}
+}
+
+func TestIncludeExclude_FolderSwitch(t *testing.T) {
+	home := setHome(t)
+	user := filepath.Join(home, ".vscode", "User")
+	write := func(rel, data string) {
+		p := filepath.Join(user, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte(data), 0644)
+	}
+	write("settings.json", `{"theme":"work"}`)
+	write("snippets/go.json", "work")
+	write("workspaceStorage/abc/state.db", "cache-1")
+	write("History/x", "h")
+
+	s, _ := newTestSwitcher(t, home)
+	tpl := AppTemplates["vscode"]
+	s.SetAppConfig("vscode", AppConfig{Accounts: []string{}, AuthPath: "~/.vscode/User", SwitchPattern: "~/profiles/vscode/{name}", Include: tpl.Include})
+	if err := s.AddAccount("vscode", "work"); err != nil {
+		t.Fatal(err)
+	}
+	snap := filepath.Join(home, "profiles", "vscode", "work")
+	if fileOrDirExists(filepath.Join(snap, "workspaceStorage")) || fileOrDirExists(filepath.Join(snap, "History")) {
+		t.Fatalf("unmanaged caches copied into the snapshot")
+	}
+	if !fileOrDirExists(filepath.Join(snap, "snippets", "go.json")) {
+		t.Fatalf("included folder missing from the snapshot")
+	}
+
+	write("settings.json", `{"theme":"home"}`)
+	os.Remove(filepath.Join(user, "snippets", "go.json"))
+	if err := s.AddAccount("vscode", "home"); err != nil {
+		t.Fatal(err)
+	}
+	// Caches changing does not make the profile look modified
+	write("workspaceStorage/abc/state.db", "cache-2")
+	if cur := s.findCurrentAccount("vscode"); cur != "home" {
+		t.Fatalf("expected current home, got %q", cur)
+	}
+
+	out, _ := captureOutput(t, func() {
+		if err := s.PreviewSwitch("vscode", "work"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "Keeps 2 unmanaged entries: History, workspaceStorage") || !strings.Contains(out, "No files would be removed") {
+		t.Fatalf("unexpected dry run: %q", out)
+	}
+
+	if err := s.SwitchAccount("vscode", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(user, "settings.json")); string(b) != `{"theme":"work"}` {
+		t.Fatalf("settings not switched: %q", b)
+	}
+	if b, _ := os.ReadFile(filepath.Join(user, "workspaceStorage", "abc", "state.db")); string(b) != "cache-2" {
+		t.Fatalf("unmanaged cache not carried over: %q", b)
+	}
+	if !fileOrDirExists(filepath.Join(user, "snippets", "go.json")) || !fileOrDirExists(filepath.Join(user, "History", "x")) {
+		t.Fatalf("switch lost managed or unmanaged content")
+	}
+
+	// Undo restores the managed content and keeps the caches
+	if err := s.Undo("vscode"); err != nil {
+		t.Fatal(err)
+	}
+	if fileOrDirExists(filepath.Join(user, "snippets", "go.json")) {
+		t.Fatalf("undo did not restore the home profile")
+	}
+	if b, _ := os.ReadFile(filepath.Join(user, "workspaceStorage", "abc", "state.db")); string(b) != "cache-2" {
+		t.Fatalf("undo dropped unmanaged cache: %q", b)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configCodec reads and writes one config file format as a tree of
+// map[string]interface{}, so keys can be selected, compared and patched the
+// same way in every format.
+type configCodec struct {
+	decode func([]byte) (map[string]interface{}, error)
+	encode func(map[string]interface{}) ([]byte, error)
+	// patch replaces the selected keys of live with those of profile while
+	// keeping the layout of live. When nil, or when it returns
+	// errKeepLayout, live is decoded, patched and encoded again.
+	patch func(live, profile []byte, keys [][]string) ([]byte, error)
+	// fold normalizes pointer tokens for formats with case-insensitive
+	// names.
+	fold func([]string) []string
+	// depth limits how deep keys can point, 0 for any depth.
+	depth int
+}
+
+// errKeepLayout is returned by a codec's patch when the change cannot be
+// made in place.
+var errKeepLayout = errors.New("cannot keep the layout")
+
+var configCodecs = map[string]configCodec{
+	"json": {decode: decodeJSONObject, encode: encodeJSONObject, patch: patchJSON},
+	"toml": {decode: decodeTOML, encode: encodeTOML, patch: patchTOML},
+	"yaml": {decode: decodeYAML, encode: encodeYAML, patch: patchYAML},
+	"ini":  {decode: decodeINI, encode: encodeINI, patch: patchINI, fold: foldINIKey, depth: 2},
+}
+
+// configFormat returns the format of an app's config file: its format
+// setting, or a guess from the file name that falls back to JSON.
+func configFormat(appConfig AppConfig) string {
+	if appConfig.Format != "" {
+		return strings.ToLower(appConfig.Format)
+	}
+	base := strings.ToLower(filepath.Base(expandPath(appConfig.AuthPath)))
+	switch filepath.Ext(base) {
+	case ".toml":
+		return "toml"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".ini", ".cfg", ".conf", ".gitconfig":
+		return "ini"
+	}
+	if base == "config" && filepath.Base(filepath.Dir(expandPath(appConfig.AuthPath))) == ".kube" {
+		return "yaml"
+	}
+	return "json"
+}
+
+// codecFor returns the codec of format.
+func codecFor(format string) (configCodec, error) {
+	codec, ok := configCodecs[format]
+	if !ok {
+		return configCodec{}, fmt.Errorf("unknown format %q: use json, toml, yaml or ini", format)
+	}
+	return codec, nil
+}
+
+// pointerTokens parses keys for a codec.
+func (c configCodec) pointerTokens(keys []string) ([][]string, error) {
+	var out [][]string
+	for _, key := range keys {
+		tokens, err := parsePointer(key)
+		if err != nil {
+			return nil, err
+		}
+		if c.depth > 0 && len(tokens) > c.depth {
+			return nil, fmt.Errorf("invalid key %q: at most %d levels", key, c.depth)
+		}
+		if c.fold != nil {
+			tokens = c.fold(tokens)
+		}
+		out = append(out, tokens)
+	}
+	return out, nil
+}
+
+func decodeTOML(data []byte) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse TOML: %w", err)
+	}
+	normalizeTOML(doc)
+	return doc, nil
+}
+
+func encodeTOML(doc map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeYAML(data []byte) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse YAML: %w", err)
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	return doc, nil
+}
+
+func encodeYAML(doc map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	enc.Close()
+	return buf.Bytes(), nil
+}
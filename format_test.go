@@ -0,0 +1,230 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigFormat(t *testing.T) {
+	setHome(t)
+	cases := map[string]AppConfig{
+		"json": {AuthPath: "~/.claude/config.json"},
+		"toml": {AuthPath: "~/.codex/config.toml"},
+		"yaml": {AuthPath: "~/.config/gh/hosts.yml"},
+		"ini":  {AuthPath: "~/.gitconfig"},
+	}
+	for want, c := range cases {
+		if got := configFormat(c); got != want {
+			t.Errorf("%s: got %s, want %s", c.AuthPath, got, want)
+		}
+	}
+	if got := configFormat(AppConfig{AuthPath: "~/.kube/config"}); got != "yaml" {
+		t.Errorf("kubeconfig: got %s", got)
+	}
+	if got := configFormat(AppConfig{AuthPath: "~/.npmrc", Format: "INI"}); got != "ini" {
+		t.Errorf("explicit format: got %s", got)
+	}
+	if err := validateAppConfig(AppConfig{AuthPath: "~/.npmrc", SwitchPattern: "~/p/{name}", Format: "xml"}); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+	if err := validateAppConfig(AppConfig{AuthPath: "~/.gitconfig", SwitchPattern: "~/p/{name}", Keys: []string{"/user/name/first"}}); err == nil {
+		t.Errorf("expected error for INI key deeper than section/key")
+	}
+}
+
+const workGitconfig = `# personal settings
+[user]
+	name = Work Person
+	email = me@work.example
+	signingkey = WORK
+[alias]
+	co = checkout
+[remote "origin"]
+	url = git@work.example:repo.git
+`
+
+func TestINIKeys(t *testing.T) {
+	keys := []string{"/user/name", "/user/email", "/user/signingKey"}
+	picked, err := selectKeys([]byte(workGitconfig), keys, "ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(picked), "alias") || !strings.Contains(string(picked), "signingkey = WORK") {
+		t.Fatalf("unexpected selection: %q", picked)
+	}
+
+	live := "[core]\n\teditor = vim\n[user]\n\tname = Home Person\n\temail = me@home.example\n# aliases\n[alias]\n\tst = status\n"
+	patched, err := patchKeys([]byte(live), picked, keys, "ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[core]\n\teditor = vim\n[user]\n\tname = Work Person\n\temail = me@work.example\n\tsigningkey = WORK\n# aliases\n[alias]\n\tst = status\n"
+	if string(patched) != want {
+		t.Fatalf("unexpected patch:\n%s\nwant:\n%s", patched, want)
+	}
+	if !keysEqual(patched, []byte(workGitconfig), keys, "ini", nil) {
+		t.Fatalf("expected patched config to match on selected keys")
+	}
+
+	// A missing section is added and subsections are matched like git does
+	picked, _ = selectKeys([]byte(workGitconfig), []string{"/remote.origin"}, "ini")
+	patched, _ = patchKeys([]byte("[alias]\n\tst = status\n"), picked, []string{"/remote.origin"}, "ini")
+	if !strings.Contains(string(patched), "[remote \"origin\"]\n\turl = git@work.example:repo.git") {
+		t.Fatalf("section not added: %q", patched)
+	}
+}
+
+func TestTOMLAndYAMLKeys(t *testing.T) {
+	tomlLive := []byte("model = \"o3\"\n\n[auth]\ntoken = \"home\"\nregion = \"eu\"\n")
+	tomlProfile, err := selectKeys([]byte("model = \"gpt\"\n[auth]\ntoken = \"work\"\n"), []string{"/auth/token"}, "toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := patchKeys(tomlLive, tomlProfile, []string{"/auth/token"}, "toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := decodeTOML(patched)
+	if doc["model"] != "o3" || doc["auth"].(map[string]interface{})["token"] != "work" || doc["auth"].(map[string]interface{})["region"] != "eu" {
+		t.Fatalf("unexpected TOML patch: %s", patched)
+	}
+
+	kube := []byte("current-context: home\ncontexts:\n- name: home\n- name: work\n")
+	patched, err = patchKeys(kube, []byte("current-context: work\n"), []string{"/current-context"}, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ydoc, _ := decodeYAML(patched)
+	if ydoc["current-context"] != "work" || len(ydoc["contexts"].([]interface{})) != 2 {
+		t.Fatalf("unexpected YAML patch: %s", patched)
+	}
+	if !keysEqual(patched, []byte("current-context: work\nother: 1\n"), []string{"/current-context"}, "yaml", nil) {
+		t.Fatalf("expected YAML documents to agree on selected keys")
+	}
+}
+
+func TestKeys_GitIdentitySwitch(t *testing.T) {
+	home := setHome(t)
+	cfg := filepath.Join(home, ".gitconfig")
+	os.WriteFile(cfg, []byte(workGitconfig), 0644)
+
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("git", AppConfig{
+		Accounts:      []string{},
+		AuthPath:      "~/.gitconfig",
+		SwitchPattern: "~/profiles/git/{name}",
+		Keys:          []string{"/user/name", "/user/email", "/user/signingkey"},
+	})
+	if err := s.AddAccount("git", "work"); err != nil {
+		t.Fatal(err)
+	}
+	home2 := strings.NewReplacer("Work Person", "Home Person", "me@work.example", "me@home.example", "WORK", "HOME").Replace(workGitconfig)
+	os.WriteFile(cfg, []byte(home2), 0644)
+	if err := s.AddAccount("git", "home"); err != nil {
+		t.Fatal(err)
+	}
+
+	// New aliases survive switching identity
+	os.WriteFile(cfg, []byte(home2+"[alias]\n\tlg = log --oneline\n"), 0644)
+	if cur := s.findCurrentAccount("git"); cur != "home" {
+		t.Fatalf("expected current home, got %q", cur)
+	}
+	if err := s.SwitchAccount("git", "work"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(cfg)
+	got := string(b)
+	if !strings.Contains(got, "email = me@work.example") || !strings.Contains(got, "lg = log --oneline") || !strings.Contains(got, "# personal settings") {
+		t.Fatalf("unexpected gitconfig after switch:\n%s", got)
+	}
+	if cur := s.findCurrentAccount("git"); cur != "work" {
+		t.Fatalf("expected current work, got %q", cur)
+	}
+}
+
+func TestTOMLPatch_KeepsLayout(t *testing.T) {
+	live := []byte(`# Codex settings
+model = "o3" # default model
+approval = "never"
+
+[auth]
+  # keep me
+  token = "home"
+  scopes = [
+    "read",
+  ]
+  region = "eu"
+
+[[servers]]
+name = "a"
+`)
+	profile := []byte("model = \"gpt\"\n[auth]\ntoken = \"work\"\nscopes = [\"read\", \"write\"]\nuser = \"w\"\n")
+	patched, err := patchKeys(live, profile, []string{"/model", "/approval", "/auth/token", "/auth/scopes", "/auth/user"}, "toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# Codex settings
+model = "gpt" # default model
+
+[auth]
+  # keep me
+  token = "work"
+  scopes = ["read", "write"]
+  region = "eu"
+  user = "w"
+
+[[servers]]
+name = "a"
+`
+	if string(patched) != want {
+		t.Fatalf("layout not kept:\n%s", patched)
+	}
+
+	// Arrays of tables are indexed, falling back to rewriting the file
+	patched, err = patchKeys(live, []byte("[[servers]]\nname = \"b\"\n"), []string{"/servers/0/name"}, "toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := decodeTOML(patched)
+	if v, _ := lookupKey(doc, []string{"servers", "0", "name"}); v != "b" {
+		t.Fatalf("array of tables not patched: %s", patched)
+	}
+}
+
+func TestYAMLPatch_KeepsLayout(t *testing.T) {
+	kube := []byte(`apiVersion: v1
+# contexts
+current-context: "home" # active
+users:
+- name: home
+  user:
+    token: t-home
+- name: work
+  user:
+    token: t-work
+`)
+	profile := []byte("current-context: work\nusers:\n- name: home\n  user:\n    token: t-new\n")
+	patched, err := patchKeys(kube, profile, []string{"/current-context", "/users/0/user/token"}, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.NewReplacer(`"home" # active`, `"work" # active`, "t-home", "t-new").Replace(string(kube))
+	if string(patched) != want {
+		t.Fatalf("layout not kept:\n%s", patched)
+	}
+
+	// Structural changes keep comments and key order
+	patched, err = patchKeys(kube, []byte("users:\n- name: home\n"), []string{"/users/1", "/preferences"}, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(patched)
+	if !strings.Contains(text, "# contexts") || !strings.Contains(text, "# active") || strings.Contains(text, "t-work") {
+		t.Fatalf("unexpected structural patch:\n%s", text)
+	}
+	if strings.Index(text, "apiVersion") > strings.Index(text, "current-context") {
+		t.Fatalf("key order changed:\n%s", text)
+	}
+}
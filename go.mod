@@ -3,3 +3,5 @@ module github.com/surajmandalcell/switch
 go 1.24.5
 
 require github.com/BurntSushi/toml v1.5.0
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// historyLimit bounds the number of switches kept in the history file.
+const historyLimit = 50
+
+// HistoryEntry records one profile switch. Undo points at a copy of the live
+// config that the switch replaced, so the switch can be reverted exactly.
+type HistoryEntry struct {
+	Time time.Time `json:"time"`
+	App  string    `json:"app"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	Undo string    `json:"undo,omitempty"`
+}
+
+func (s *Switcher) historyPath() string {
+	return filepath.Join(s.stateDir(), "history.json")
+}
+
+func (s *Switcher) loadHistory() ([]HistoryEntry, error) {
+	data, err := os.ReadFile(s.historyPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	var entries []HistoryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse history: %w", err)
+	}
+	return entries, nil
+}
+
+func (s *Switcher) saveHistory(entries []HistoryEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode history: %w", err)
+	}
+	if err := os.MkdirAll(s.stateDir(), 0700); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	if err := writeFileAtomic(s.historyPath(), data, 0600); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	return nil
+}
+
+// captureUndo copies the live config that a switch is about to replace into
+// the state directory and returns the path of the copy. It returns "" when
+// there is no live config to preserve.
+func (s *Switcher) captureUndo(appConfig AppConfig) (string, error) {
+	if !liveExists(appConfig) {
+		return "", nil
+	}
+	dir := filepath.Join(s.stateDir(), "undo", strconv.FormatInt(time.Now().UnixNano(), 10))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, liveBase(appConfig))
+	if err := s.copyOut(appConfig, dst); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dst, nil
+}
+
+// discardUndo removes an undo copy created by captureUndo.
+func discardUndo(undo string) {
+	if undo != "" {
+		os.RemoveAll(filepath.Dir(undo))
+	}
+}
+
+// revertSwitch puts back the live config that a switch replaced, from the
+// undo copy taken by captureUndo. Without a copy there was no live config,
+// so the restored one is removed.
+func (s *Switcher) revertSwitch(appConfig AppConfig, undo string) error {
+	defer discardUndo(undo)
+	if undo != "" {
+		return s.restoreSnapshot(appConfig, undo)
+	}
+	for _, lp := range livePaths(appConfig) {
+		if err := os.RemoveAll(lp.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordSwitch appends a switch to the history, dropping the oldest entries
+// and their undo copies once the history grows past historyLimit.
+func (s *Switcher) recordSwitch(entry HistoryEntry) error {
+	entries, err := s.loadHistory()
+	if err != nil {
+		return err
+	}
+	entries = append(entries, entry)
+	for len(entries) > historyLimit {
+		discardUndo(entries[0].Undo)
+		entries = entries[1:]
+	}
+	return s.saveHistory(entries)
+}
+
+// forgetSwitch removes entry from the history, for a switch that was
+// reverted after it was recorded.
+func (s *Switcher) forgetSwitch(entry HistoryEntry) error {
+	entries, err := s.loadHistory()
+	if err != nil {
+		return err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].App == entry.App && entries[i].Time.Equal(entry.Time) {
+			return s.saveHistory(append(entries[:i], entries[i+1:]...))
+		}
+	}
+	return nil
+}
+
+// rewriteHistory applies edit to every recorded switch and saves the
+// history, so it keeps pointing at profiles and apps that were renamed or
+// removed.
+func (s *Switcher) rewriteHistory(edit func(*HistoryEntry)) error {
+	entries, err := s.loadHistory()
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	for i := range entries {
+		edit(&entries[i])
+	}
+	return s.saveHistory(entries)
+}
+
+// renameAppState moves the history and stashed configs of an app to its new
+// name. Undo copies are not named after the app and stay where they are.
+func (s *Switcher) renameAppState(oldName, newName string) error {
+	stash := filepath.Join(s.stateDir(), "stash")
+	moved := false
+	if fileOrDirExists(filepath.Join(stash, oldName)) {
+		if fileOrDirExists(filepath.Join(stash, newName)) {
+			return fmt.Errorf("stash already exists: %s", filepath.Join(stash, newName))
+		}
+		if err := os.Rename(filepath.Join(stash, oldName), filepath.Join(stash, newName)); err != nil {
+			return fmt.Errorf("move stash: %w", err)
+		}
+		moved = true
+	}
+	err := s.rewriteHistory(func(e *HistoryEntry) {
+		if e.App == oldName {
+			e.App = newName
+		}
+	})
+	if err != nil && moved {
+		os.Rename(filepath.Join(stash, newName), filepath.Join(stash, oldName))
+	}
+	return err
+}
+
+// SwitchBack switches appName to the profile it was on before the last
+// switch, like `cd -`.
+func (s *Switcher) SwitchBack(appName string) error {
+	if _, exists := s.GetAppConfig(appName); !exists {
+		return fmt.Errorf("no configuration found for app '%s'", appName)
+	}
+	entries, err := s.loadHistory()
+	if err != nil {
+		return err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.App == appName && e.From != "" && e.From != e.To {
+			return s.SwitchAccount(appName, e.From)
+		}
+	}
+	return fmt.Errorf("no previous profile recorded for %s", appName)
+}
+
+// Undo reverts the most recent switch, or the most recent switch of appName
+// if it is not empty. The live config is restored to the exact content the
+// switch replaced and the entry is removed from the history.
+func (s *Switcher) Undo(appName string) error {
+	entries, err := s.loadHistory()
+	if err != nil {
+		return err
+	}
+	idx := -1
+	for i := len(entries) - 1; i >= 0; i-- {
+		if appName == "" || entries[i].App == appName {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		if appName != "" {
+			return fmt.Errorf("no switch to undo for %s", appName)
+		}
+		return fmt.Errorf("no switch to undo")
+	}
+	entry := entries[idx]
+
+	appConfig, err := s.resolveApp(entry.App)
+	if err != nil {
+		return err
+	}
+	if err := s.checkSchemaVersion(); err != nil {
+		return err
+	}
+	if entry.Undo == "" || !fileOrDirExists(entry.Undo) {
+		return fmt.Errorf("cannot undo switch of %s: the replaced config was not recorded", entry.App)
+	}
+	if drifted, origin := s.checkDrift(entry.App, s.findCurrentAccount(entry.App)); drifted {
+		if err := s.resolveDrift(entry.App, origin); err != nil {
+			return err
+		}
+		appConfig, _ = s.GetAppConfig(entry.App)
+	}
+	// Undoing is a switch back to entry.From, so it runs the same hooks.
+	livePathList := liveLabel(appConfig, string(os.PathListSeparator))
+	if err := runHook("pre_switch", appConfig.PreSwitch, entry.App, livePathList, entry.To, entry.From); err != nil {
+		return fmt.Errorf("undo aborted: %w", err)
+	}
+	if err := s.restoreSnapshot(appConfig, entry.Undo); err != nil {
+		return fmt.Errorf("restore config: %w", err)
+	}
+
+	appConfig.Current = entry.From
+	s.SetAppConfig(entry.App, appConfig)
+	if err := s.saveConfig(); err != nil {
+		return err
+	}
+	discardUndo(entry.Undo)
+	if err := s.saveHistory(append(entries[:idx], entries[idx+1:]...)); err != nil {
+		return err
+	}
+
+	from := entry.From
+	if from == "" {
+		from = "previous config"
+	}
+	fmt.Printf("%s✓ Undid %s switch: %s restored (was %s)%s\n", ColorGreen, entry.App, from, entry.To, ColorReset)
+	if err := runHook("post_switch", appConfig.PostSwitch, entry.App, livePathList, entry.To, entry.From); err != nil {
+		fmt.Fprintf(os.Stderr, "%s! %v%s\n", ColorYellow, err, ColorReset)
+	}
+	return nil
+}
+
+// ShowHistory prints recorded switches, newest first.
+func (s *Switcher) ShowHistory(appName string) error {
+	entries, err := s.loadHistory()
+	if err != nil {
+		return err
+	}
+	var shown int
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if appName != "" && e.App != appName {
+			continue
+		}
+		if shown == 0 {
+			fmt.Printf("%sSwitch history:%s\n", ColorCyan, ColorReset)
+		}
+		from := e.From
+		if from == "" {
+			from = "(unsaved)"
+		}
+		fmt.Printf("  %s  %-8s %s → %s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.App, from, e.To)
+		shown++
+	}
+	if shown == 0 {
+		fmt.Printf("No switches recorded\n")
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSwitchBack(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`, "c": `{"token":"c"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b", "c"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	if err := s.SwitchBack("codex"); err == nil {
+		t.Fatalf("expected error without history")
+	}
+	if err := s.SwitchAccount("codex", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if code := handleApp(s, "codex", []string{"-"}); code != 0 {
+		t.Fatalf("switch - failed: %d", code)
+	}
+	if b, _ := os.ReadFile(authPath); string(b) != `{"token":"a"}` {
+		t.Fatalf("expected back on a, got %s", string(b))
+	}
+	// Going back again toggles like cd -
+	if err := s.SwitchBack("codex"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(authPath); string(b) != `{"token":"c"}` {
+		t.Fatalf("expected back on c, got %s", string(b))
+	}
+	entries, err := s.loadHistory()
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("expected 3 history entries, got %d (%v)", len(entries), err)
+	}
+	if entries[0].From != "a" || entries[0].To != "c" {
+		t.Fatalf("unexpected first entry: %+v", entries[0])
+	}
+	if err := s.SwitchBack("nosuch"); err == nil {
+		t.Fatalf("expected error for unknown app")
+	}
+}
+
+func TestUndo_RestoresReplacedContent(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a-refreshed"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	if err := s.Undo(""); err == nil || !strings.Contains(err.Error(), "no switch to undo") {
+		t.Fatalf("expected nothing to undo, got %v", err)
+	}
+	// Discard the unsaved changes while switching, then undo
+	withStdin(t, "2\n", func() {
+		if err := s.SwitchAccount("codex", "b"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if code := handleUndo(s, nil); code != 0 {
+		t.Fatalf("undo failed: %d", code)
+	}
+	if b, _ := os.ReadFile(authPath); string(b) != `{"token":"a-refreshed"}` {
+		t.Fatalf("undo did not restore exact live content: %s", string(b))
+	}
+	if app, _ := s.GetAppConfig("codex"); app.Current != "a" {
+		t.Fatalf("current not restored: %+v", app)
+	}
+	if entries, _ := s.loadHistory(); len(entries) != 0 {
+		t.Fatalf("undo should pop the history entry, got %d", len(entries))
+	}
+	undos, _ := filepath.Glob(filepath.Join(home, ".switch", "undo", "*"))
+	if len(undos) != 0 {
+		t.Fatalf("undo copy not cleaned up: %v", undos)
+	}
+	if err := s.Undo("codex"); err == nil {
+		t.Fatalf("expected nothing left to undo for codex")
+	}
+}
+
+func TestRemoveAccount_DeletesUndoCopies(t *testing.T) {
+	home := setHome(t)
+	setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`, "c": `{"token":"c"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b", "c"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	for _, to := range []string{"c", "b", "a"} {
+		if err := s.SwitchAccount("codex", to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, _ := s.loadHistory()
+	os.MkdirAll(filepath.Join(s.stateDir(), "stash", "codex"), 0700)
+	out, _ := captureOutput(t, func() {
+		if err := s.RemoveAccount("codex", "b", true); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "Deleted 2 undo copies") || !strings.Contains(out, "Stashed codex configs") {
+		t.Fatalf("remaining copies not reported: %q", out)
+	}
+	entries, _ := s.loadHistory()
+	if entries[0].Undo == "" || !fileOrDirExists(entries[0].Undo) {
+		t.Fatalf("undo copy of an unrelated switch deleted: %+v", entries[0])
+	}
+	for i, e := range entries[1:] {
+		if e.Undo != "" || fileOrDirExists(before[i+1].Undo) {
+			t.Fatalf("undo copy around the removed profile kept: %+v", e)
+		}
+	}
+}
+
+func TestRecordSwitch_PrunesOldEntries(t *testing.T) {
+	home := setHome(t)
+	s, _ := newTestSwitcher(t, home)
+	oldest := filepath.Join(home, "undo-0", "auth.json")
+	os.MkdirAll(filepath.Dir(oldest), 0700)
+	os.WriteFile(oldest, []byte("x"), 0600)
+	for i := 0; i <= historyLimit; i++ {
+		e := HistoryEntry{Time: time.Now(), App: "codex", From: "a", To: "b"}
+		if i == 0 {
+			e.Undo = oldest
+		}
+		if err := s.recordSwitch(e); err != nil {
+			t.Fatalf("recordSwitch: %v", err)
+		}
+	}
+	entries, _ := s.loadHistory()
+	if len(entries) != historyLimit {
+		t.Fatalf("expected %d entries, got %d", historyLimit, len(entries))
+	}
+	if fileOrDirExists(oldest) {
+		t.Fatalf("undo copy of pruned entry not removed")
+	}
+}
+
+func TestHistoryCommands(t *testing.T) {
+	home := setHome(t)
+	setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	out, _ := captureOutput(t, func() { _ = handleHistory(s, nil) })
+	if !strings.Contains(out, "No switches recorded") {
+		t.Fatalf("expected empty history: %q", out)
+	}
+	if err := s.SwitchAccount("codex", "b"); err != nil {
+		t.Fatal(err)
+	}
+	out2, _ := captureOutput(t, func() { _ = handleHistory(s, []string{"codex"}) })
+	if !strings.Contains(out2, "a → b") {
+		t.Fatalf("expected switch in history: %q", out2)
+	}
+	if code := handleHistory(s, []string{"a", "b"}); code != 1 {
+		t.Fatalf("expected usage error")
+	}
+	if code := handleUndo(s, []string{"a", "b"}); code != 1 {
+		t.Fatalf("expected usage error")
+	}
+	os.WriteFile(s.historyPath(), []byte("not json"), 0600)
+	if code := handleHistory(s, nil); code != 1 {
+		t.Fatalf("expected parse error")
+	}
+}
+
+func TestRename_UpdatesHistory(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	if err := s.SwitchAccount("codex", "b"); err != nil {
+		t.Fatal(err)
+	}
+	stash := filepath.Join(home, ".switch", "stash", "codex", "1")
+	os.MkdirAll(stash, 0700)
+
+	if err := s.RenameAccount("codex", "a", "alpha"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RenameApp("codex", "openai"); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := s.loadHistory()
+	if len(entries) != 1 || entries[0].App != "openai" || entries[0].From != "alpha" || entries[0].To != "b" {
+		t.Fatalf("history not renamed: %+v", entries)
+	}
+	if !fileOrDirExists(filepath.Join(home, ".switch", "stash", "openai", "1")) {
+		t.Fatalf("stash not moved with the app")
+	}
+	if err := s.SwitchBack("openai"); err != nil {
+		t.Fatalf("switch back after rename: %v", err)
+	}
+	if b, _ := os.ReadFile(authPath); string(b) != `{"token":"a"}` {
+		t.Fatalf("expected alpha's content, got %s", b)
+	}
+	if err := s.Undo("openai"); err != nil {
+		t.Fatalf("undo after rename: %v", err)
+	}
+	if b, _ := os.ReadFile(authPath); string(b) != `{"token":"b"}` {
+		t.Fatalf("undo did not restore b, got %s", b)
+	}
+}
+
+func TestRename_RestoresStateWhenSaveFails(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "~/p/{app}/{name}"})
+	os.MkdirAll(filepath.Join(home, "p", "codex"), 0755)
+	os.WriteFile(filepath.Join(home, "p", "codex", "a"), []byte(`{"token":"a"}`), 0600)
+	s.config.Default.Config = "codex"
+	s.config.Contexts = map[string]map[string]string{"work": {"codex": "a"}}
+	// A config from a newer version cannot be written.
+	s.config.SchemaVersion = configSchemaVersion + 1
+
+	if err := s.RenameApp("codex", "openai"); err == nil {
+		t.Fatalf("expected save error")
+	}
+	if _, ok := s.GetAppConfig("openai"); ok || s.config.Default.Config != "codex" || s.config.Contexts["work"]["codex"] != "a" {
+		t.Fatalf("config not restored after failed app rename")
+	}
+	if !fileOrDirExists(filepath.Join(home, "p", "codex", "a")) {
+		t.Fatalf("snapshot not moved back")
+	}
+	if err := s.RenameAccount("codex", "a", "alpha"); err == nil {
+		t.Fatalf("expected save error")
+	}
+	if app, _ := s.GetAppConfig("codex"); !contains(app.Accounts, "a") || app.Current != "a" || s.config.Contexts["work"]["codex"] != "a" {
+		t.Fatalf("config not restored after failed profile rename: %+v", app)
+	}
+	if !fileOrDirExists(filepath.Join(home, "p", "codex", "a")) {
+		t.Fatalf("snapshot not moved back")
+	}
+
+	// A profile without a snapshot can still be renamed.
+	s.config.SchemaVersion = configSchemaVersion
+	if err := s.RenameAccount("codex", "b", "beta"); err != nil {
+		t.Fatalf("rename without snapshot: %v", err)
+	}
+	if b, _ := os.ReadFile(authPath); string(b) != `{"token":"a"}` {
+		t.Fatalf("live config changed: %s", b)
+	}
+}
+
+func TestSwitchAccount_RevertsWhenHistoryFails(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	// A folder where the history file should be makes recording fail.
+	os.MkdirAll(s.historyPath(), 0700)
+
+	if err := s.SwitchAccount("codex", "b"); err == nil || !strings.Contains(err.Error(), "record switch") {
+		t.Fatalf("expected record error, got %v", err)
+	}
+	if b, _ := os.ReadFile(authPath); string(b) != `{"token":"a"}` {
+		t.Fatalf("switch not reverted: %s", b)
+	}
+	if app, _ := s.GetAppConfig("codex"); app.Current != "a" {
+		t.Fatalf("current changed: %+v", app)
+	}
+	if undos, _ := filepath.Glob(filepath.Join(home, ".switch", "undo", "*")); len(undos) != 0 {
+		t.Fatalf("undo copy left behind: %v", undos)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// hookCommand builds the shell invocation used to run a hook command line.
+func hookCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// runHook runs a pre_switch or post_switch command for appName. The app, the
+// profile being left and the profile being switched to are exposed to the
+// command as SWITCH_APP, SWITCH_FROM and SWITCH_TO.
+func runHook(name, command, appName, authPath, from, to string) error {
+	if command == "" {
+		return nil
+	}
+	cmd := hookCommand(command)
+	cmd.Env = append(os.Environ(),
+		"SWITCH_HOOK="+name,
+		"SWITCH_APP="+appName,
+		"SWITCH_FROM="+from,
+		"SWITCH_TO="+to,
+		"SWITCH_AUTH_PATH="+authPath,
+	)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook %q: %w", name, command, err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func setupHookApp(t *testing.T, pre, post string) (*Switcher, string, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("hook tests use sh")
+	}
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch", PreSwitch: pre, PostSwitch: post})
+	return s, home, authPath
+}
+
+func TestSwitchHooks_Environment(t *testing.T) {
+	s, home, authPath := setupHookApp(t,
+		`echo "pre $SWITCH_APP $SWITCH_FROM $SWITCH_TO $(cat "$SWITCH_AUTH_PATH")" >> "$HOME/hooks.log"`,
+		`echo "post $SWITCH_APP $SWITCH_FROM $SWITCH_TO $(cat "$SWITCH_AUTH_PATH")" >> "$HOME/hooks.log"`)
+	if err := s.SwitchAccount("codex", "b"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(home, "hooks.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "pre codex a b {\"token\":\"a\"}\npost codex a b {\"token\":\"b\"}\n"
+	if string(b) != want {
+		t.Fatalf("unexpected hook log:\n%s\nwant:\n%s", b, want)
+	}
+	if got, _ := os.ReadFile(authPath); string(got) != `{"token":"b"}` {
+		t.Fatalf("switch not applied: %s", got)
+	}
+}
+
+func TestSwitchHooks_PreFailureAborts(t *testing.T) {
+	s, home, authPath := setupHookApp(t, "exit 3", `touch "$HOME/post-ran"`)
+	err := s.SwitchAccount("codex", "b")
+	if err == nil || !strings.Contains(err.Error(), "pre_switch") {
+		t.Fatalf("expected pre_switch error, got %v", err)
+	}
+	if got, _ := os.ReadFile(authPath); string(got) != `{"token":"a"}` {
+		t.Fatalf("live config changed after failed pre hook: %s", got)
+	}
+	if app, _ := s.GetAppConfig("codex"); app.Current != "a" {
+		t.Fatalf("current changed: %+v", app)
+	}
+	if fileOrDirExists(filepath.Join(home, "post-ran")) {
+		t.Fatalf("post hook ran after aborted switch")
+	}
+	if entries, _ := s.loadHistory(); len(entries) != 0 {
+		t.Fatalf("aborted switch recorded in history: %+v", entries)
+	}
+}
+
+func TestSwitchHooks_PostFailureReported(t *testing.T) {
+	s, _, authPath := setupHookApp(t, "", "exit 2")
+	_, errOut := captureOutput(t, func() {
+		if err := s.SwitchAccount("codex", "b"); err != nil {
+			t.Fatalf("post hook failure should not fail the switch: %v", err)
+		}
+	})
+	if !strings.Contains(errOut, "post_switch hook") {
+		t.Fatalf("post hook failure not reported: %q", errOut)
+	}
+	if got, _ := os.ReadFile(authPath); string(got) != `{"token":"b"}` {
+		t.Fatalf("switch not applied: %s", got)
+	}
+}
+
+func TestSwitchHooks_OmittedFromConfig(t *testing.T) {
+	s, _, _ := setupHookApp(t, "", "")
+	if err := s.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(s.configPath)
+	if strings.Contains(string(b), "pre_switch") || strings.Contains(string(b), "post_switch") {
+		t.Fatalf("empty hooks written to config:\n%s", b)
+	}
+	s.SetAppConfig("codex", AppConfig{Accounts: []string{}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch", PreSwitch: "ssh-add -D"})
+	s.saveConfig()
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if app, _ := s.GetAppConfig("codex"); app.PreSwitch != "ssh-add -D" {
+		t.Fatalf("pre_switch not persisted: %+v", app)
+	}
+}
+
+func TestSwitchHooks_Undo(t *testing.T) {
+	s, home, authPath := setupHookApp(t,
+		`echo "pre $SWITCH_FROM $SWITCH_TO" >> "$HOME/hooks.log"`,
+		`echo "post $SWITCH_FROM $SWITCH_TO $(cat "$SWITCH_AUTH_PATH")" >> "$HOME/hooks.log"`)
+	if err := s.SwitchAccount("codex", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Undo("codex"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(filepath.Join(home, "hooks.log"))
+	want := "pre a b\npost a b {\"token\":\"b\"}\npre b a\npost b a {\"token\":\"a\"}\n"
+	if string(b) != want {
+		t.Fatalf("unexpected hook log:\n%s\nwant:\n%s", b, want)
+	}
+
+	// A failing pre_switch hook stops the undo like it stops a switch.
+	if err := s.SwitchAccount("codex", "b"); err != nil {
+		t.Fatal(err)
+	}
+	appConfig, _ := s.GetAppConfig("codex")
+	appConfig.PreSwitch = "exit 3"
+	s.SetAppConfig("codex", appConfig)
+	if err := s.Undo("codex"); err == nil || !strings.Contains(err.Error(), "undo aborted") {
+		t.Fatalf("expected undo to be aborted, got %v", err)
+	}
+	if got, _ := os.ReadFile(authPath); string(got) != `{"token":"b"}` {
+		t.Fatalf("live config changed after aborted undo: %s", got)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// iniLine is one line of an INI file such as ~/.gitconfig. section is the
+// normalized name of the section the line belongs to, and key is set for
+// entries.
+type iniLine struct {
+	text    string
+	section string
+	key     string
+	header  bool
+}
+
+// iniFile keeps every line of an INI file so it can be patched without
+// losing comments, includes or repeated keys.
+type iniFile struct {
+	lines []iniLine
+}
+
+func parseINI(data []byte) *iniFile {
+	f := &iniFile{}
+	text := strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if text == "" {
+		return f
+	}
+	section := ""
+	for _, raw := range strings.Split(text, "\n") {
+		line := iniLine{text: raw, section: section}
+		trimmed := strings.TrimSpace(raw)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+		case strings.HasPrefix(trimmed, "[") && strings.Contains(trimmed, "]"):
+			section = iniSectionName(trimmed[1:strings.Index(trimmed, "]")])
+			line.section = section
+			line.header = true
+		default:
+			name, _, _ := strings.Cut(trimmed, "=")
+			line.key = strings.ToLower(strings.TrimSpace(name))
+		}
+		f.lines = append(f.lines, line)
+	}
+	return f
+}
+
+// iniSectionName normalizes a section header the way git does: [remote
+// "origin"] and [remote.origin] both become remote.origin, with the section
+// name lowercased and the subsection kept as written.
+func iniSectionName(header string) string {
+	header = strings.TrimSpace(header)
+	if name, sub, ok := strings.Cut(header, " "); ok {
+		return strings.ToLower(name) + "." + strings.Trim(strings.TrimSpace(sub), `"`)
+	}
+	if name, sub, ok := strings.Cut(header, "."); ok {
+		return strings.ToLower(name) + "." + sub
+	}
+	return strings.ToLower(header)
+}
+
+// foldINIKey normalizes pointer tokens to match iniSectionName and the
+// lowercased entry names.
+func foldINIKey(tokens []string) []string {
+	out := []string{iniSectionName(tokens[0])}
+	for _, tok := range tokens[1:] {
+		out = append(out, strings.ToLower(tok))
+	}
+	return out
+}
+
+func iniValue(line iniLine) string {
+	_, value, ok := strings.Cut(strings.TrimSpace(line.text), "=")
+	if !ok {
+		return "true"
+	}
+	return strings.TrimSpace(value)
+}
+
+// entries returns the entries of section, or only those named key when key
+// is set.
+func (f *iniFile) entries(section, key string) []iniLine {
+	var out []iniLine
+	for _, line := range f.lines {
+		if line.key != "" && line.section == section && (key == "" || line.key == key) {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+func (f *iniFile) headerText(section string) string {
+	for _, line := range f.lines {
+		if line.header && line.section == section {
+			return strings.TrimSpace(line.text)
+		}
+	}
+	if name, sub, ok := strings.Cut(section, "."); ok {
+		return "[" + name + ` "` + sub + `"]`
+	}
+	return "[" + section + "]"
+}
+
+// set replaces the entries of section named key, or all its entries when
+// key is empty, with entries. New entries go after the last entry of the
+// section; a missing section is added at the end using header.
+func (f *iniFile) set(section, key string, entries []iniLine, header string) {
+	var kept []iniLine
+	for _, line := range f.lines {
+		if line.key != "" && line.section == section && (key == "" || line.key == key) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	f.lines = kept
+	if len(entries) == 0 {
+		return
+	}
+	var add []iniLine
+	for _, e := range entries {
+		add = append(add, iniLine{text: "\t" + strings.TrimSpace(e.text), section: section, key: e.key})
+	}
+	at := -1
+	for i, line := range f.lines {
+		if line.section == section && (line.header || line.key != "") {
+			at = i + 1
+		}
+	}
+	if at < 0 {
+		f.lines = append(f.lines, iniLine{text: header, section: section, header: true})
+		f.lines = append(f.lines, add...)
+		return
+	}
+	f.lines = append(f.lines[:at], append(add, f.lines[at:]...)...)
+}
+
+func (f *iniFile) bytes() []byte {
+	if len(f.lines) == 0 {
+		return nil
+	}
+	var texts []string
+	for _, line := range f.lines {
+		texts = append(texts, line.text)
+	}
+	return []byte(strings.Join(texts, "\n") + "\n")
+}
+
+// decodeINI reads the entries of an INI file as section -> key -> value.
+// Repeated keys become a list of values.
+func decodeINI(data []byte) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	for _, line := range parseINI(data).lines {
+		if line.key == "" || line.section == "" {
+			continue
+		}
+		sec, ok := doc[line.section].(map[string]interface{})
+		if !ok {
+			sec = map[string]interface{}{}
+			doc[line.section] = sec
+		}
+		value := iniValue(line)
+		switch prev := sec[line.key].(type) {
+		case nil:
+			sec[line.key] = value
+		case string:
+			sec[line.key] = []interface{}{prev, value}
+		case []interface{}:
+			sec[line.key] = append(prev, value)
+		}
+	}
+	return doc, nil
+}
+
+// encodeINI writes a tree from decodeINI back as an INI file.
+func encodeINI(doc map[string]interface{}) ([]byte, error) {
+	f := &iniFile{}
+	sections := make([]string, 0, len(doc))
+	for section := range doc {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	for _, section := range sections {
+		entries, _ := doc[section].(map[string]interface{})
+		var add []iniLine
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			values, ok := entries[key].([]interface{})
+			if !ok {
+				values = []interface{}{entries[key]}
+			}
+			for _, v := range values {
+				add = append(add, iniLine{text: key + " = " + fmt.Sprint(v), key: key})
+			}
+		}
+		f.set(section, "", add, f.headerText(section))
+	}
+	return f.bytes(), nil
+}
+
+// patchINI copies the selected sections or entries of profile into live,
+// keeping the rest of live as written.
+func patchINI(live, profile []byte, keys [][]string) ([]byte, error) {
+	lf := parseINI(live)
+	pf := parseINI(profile)
+	for _, tokens := range keys {
+		section, key := tokens[0], ""
+		if len(tokens) > 1 {
+			key = tokens[1]
+		}
+		lf.set(section, key, pf.entries(section, key), pf.headerText(section))
+	}
+	return lf.bytes(), nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// jsonNode is the span of one value in a JSON document. Objects list their
+// members and arrays their elements, so a value can be replaced, removed or
+// added by editing the bytes around it.
+type jsonNode struct {
+	start, end int
+	kind       byte // '{', '[' or 0 for other values
+	members    []jsonMember
+	elems      []*jsonNode
+}
+
+// jsonMember is one member of an object. keyStart is where its quoted name
+// begins and keyEnd where it ends.
+type jsonMember struct {
+	key              string
+	keyStart, keyEnd int
+	value            *jsonNode
+}
+
+// scanJSON returns the spans of a document. data must be valid JSON.
+func scanJSON(data []byte) (*jsonNode, error) {
+	if _, err := decodeJSON(data); err != nil {
+		return nil, fmt.Errorf("parse JSON: %w", err)
+	}
+	node, _ := scanJSONValue(data, skipJSONSpace(data, 0))
+	return node, nil
+}
+
+func skipJSONSpace(data []byte, pos int) int {
+	for pos < len(data) && (data[pos] == ' ' || data[pos] == '\t' || data[pos] == '\n' || data[pos] == '\r') {
+		pos++
+	}
+	return pos
+}
+
+// scanJSONValue scans the value starting at pos and returns it with the
+// position after it.
+func scanJSONValue(data []byte, pos int) (*jsonNode, int) {
+	node := &jsonNode{start: pos}
+	switch data[pos] {
+	case '{':
+		node.kind = '{'
+		pos = skipJSONSpace(data, pos+1)
+		for data[pos] != '}' {
+			keyEnd := scanJSONString(data, pos)
+			var key string
+			json.Unmarshal(data[pos:keyEnd], &key)
+			colon := skipJSONSpace(data, keyEnd)
+			value, next := scanJSONValue(data, skipJSONSpace(data, colon+1))
+			node.members = append(node.members, jsonMember{key: key, keyStart: pos, keyEnd: keyEnd, value: value})
+			pos = skipJSONSpace(data, next)
+			if data[pos] == ',' {
+				pos = skipJSONSpace(data, pos+1)
+			}
+		}
+		pos++
+	case '[':
+		node.kind = '['
+		pos = skipJSONSpace(data, pos+1)
+		for data[pos] != ']' {
+			elem, next := scanJSONValue(data, pos)
+			node.elems = append(node.elems, elem)
+			pos = skipJSONSpace(data, next)
+			if data[pos] == ',' {
+				pos = skipJSONSpace(data, pos+1)
+			}
+		}
+		pos++
+	case '"':
+		pos = scanJSONString(data, pos)
+	default:
+		for pos < len(data) && !bytes.ContainsRune([]byte(",]} \t\r\n"), rune(data[pos])) {
+			pos++
+		}
+	}
+	node.end = pos
+	return node, pos
+}
+
+// scanJSONString returns the position after the string starting at pos.
+func scanJSONString(data []byte, pos int) int {
+	for pos++; data[pos] != '"'; pos++ {
+		if data[pos] == '\\' {
+			pos++
+		}
+	}
+	return pos + 1
+}
+
+// child returns the member or element of n that tok names, and its index.
+func (n *jsonNode) child(tok string) (*jsonNode, int) {
+	switch n.kind {
+	case '{':
+		// The last of duplicate names wins, as when decoding.
+		for i := len(n.members) - 1; i >= 0; i-- {
+			if n.members[i].key == tok {
+				return n.members[i].value, i
+			}
+		}
+	case '[':
+		if i, ok := arrayIndex(tok); ok && i < len(n.elems) {
+			return n.elems[i], i
+		}
+	}
+	return nil, -1
+}
+
+// entries returns the spans of the members or elements of n, from the start
+// of a member's name to the end of its value.
+func (n *jsonNode) entries() [][2]int {
+	var out [][2]int
+	for _, m := range n.members {
+		out = append(out, [2]int{m.keyStart, m.value.end})
+	}
+	for _, e := range n.elems {
+		out = append(out, [2]int{e.start, e.end})
+	}
+	return out
+}
+
+// patchJSON patches the selected keys of profile into live by editing only
+// the bytes of those values, so the order, indentation and formatting of
+// everything else in live are kept. Added values are indented like their
+// neighbours.
+func patchJSON(live, profile []byte, keys [][]string) ([]byte, error) {
+	if len(bytes.TrimSpace(live)) == 0 {
+		return nil, errKeepLayout
+	}
+	stored, err := decodeJSONObject(profile)
+	if err != nil {
+		return nil, fmt.Errorf("profile: %w", err)
+	}
+	out := live
+	for _, tokens := range keys {
+		value, ok := lookupKey(stored, tokens)
+		if out, err = patchJSONKey(out, tokens, value, ok); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// patchJSONKey sets the value at tokens in data, or removes it when present
+// is false.
+func patchJSONKey(data []byte, tokens []string, value interface{}, present bool) ([]byte, error) {
+	root, err := scanJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("live config: %w", err)
+	}
+	if root.kind != '{' {
+		return nil, errKeepLayout
+	}
+	parent := root
+	for i, tok := range tokens {
+		node, index := parent.child(tok)
+		if node == nil {
+			if !present {
+				return data, nil
+			}
+			rest := setIn(nil, tokens[i+1:], value)
+			if parent.kind == '{' {
+				return insertJSON(data, parent, tok, rest)
+			}
+			if at, ok := arrayIndex(tok); ok && parent.kind == '[' && at == len(parent.elems) {
+				return insertJSON(data, parent, "", rest)
+			}
+			return nil, errKeepLayout
+		}
+		if i == len(tokens)-1 {
+			if !present {
+				return deleteJSON(data, parent, index), nil
+			}
+			return replaceJSON(data, parent, node, value)
+		}
+		parent = node
+	}
+	return data, nil
+}
+
+// replaceJSON writes value over node, a child of parent.
+func replaceJSON(data []byte, parent, node *jsonNode, value interface{}) ([]byte, error) {
+	if old, err := decodeJSON(data[node.start:node.end]); err == nil && valuesEqual(old, value) {
+		return data, nil
+	}
+	compact := !spansLines(data, parent) || (node.kind != 0 && !spansLines(data, node))
+	text, err := marshalJSONValue(value, lineIndent(data, node.start), indentUnit(data), compact)
+	if err != nil {
+		return nil, err
+	}
+	return splice(data, node.start, node.end, text), nil
+}
+
+// deleteJSON removes the member or element at index from parent, with the
+// separator before or after it.
+func deleteJSON(data []byte, parent *jsonNode, index int) []byte {
+	entries := parent.entries()
+	switch {
+	case len(entries) == 1:
+		return splice(data, parent.start+1, parent.end-1, nil)
+	case index < len(entries)-1:
+		return splice(data, entries[index][0], entries[index+1][0], nil)
+	default:
+		return splice(data, entries[index-1][1], entries[index][1], nil)
+	}
+}
+
+// insertJSON adds value at the end of parent, as the member key of an object
+// or as the last element of an array.
+func insertJSON(data []byte, parent *jsonNode, key string, value interface{}) ([]byte, error) {
+	unit := indentUnit(data)
+	entries := parent.entries()
+	multiline := spansLines(data, parent) || (len(entries) == 0 && bytes.IndexByte(data, '\n') >= 0)
+	indent := ""
+	if multiline {
+		indent = lineIndent(data, parent.start) + unit
+		if len(entries) > 0 {
+			indent = lineIndent(data, entries[len(entries)-1][0])
+		}
+	}
+	text, err := marshalJSONValue(value, indent, unit, !multiline)
+	if err != nil {
+		return nil, err
+	}
+	if parent.kind == '{' {
+		name, _ := marshalJSONValue(key, "", "", true)
+		colon := ":"
+		if multiline {
+			colon = ": "
+		}
+		if n := len(parent.members); n > 0 {
+			last := parent.members[n-1]
+			colon = string(data[last.keyEnd:last.value.start])
+		}
+		text = append(append(name, colon...), text...)
+	}
+	if len(entries) == 0 {
+		if multiline {
+			text = append([]byte("\n"+indent), append(text, "\n"+lineIndent(data, parent.start)...)...)
+		}
+		return splice(data, parent.start+1, parent.end-1, text), nil
+	}
+	sep := ","
+	if n := len(entries); n > 1 {
+		sep = string(data[entries[n-2][1]:entries[n-1][0]])
+	} else if multiline {
+		sep = ",\n" + indent
+	}
+	end := entries[len(entries)-1][1]
+	return splice(data, end, end, append([]byte(sep), text...)), nil
+}
+
+// marshalJSONValue encodes value for a place whose line is indented by
+// prefix, or on one line when compact.
+func marshalJSONValue(value interface{}, prefix, unit string, compact bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if !compact {
+		enc.SetIndent(prefix, unit)
+	}
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+func splice(data []byte, start, end int, text []byte) []byte {
+	out := make([]byte, 0, len(data)-(end-start)+len(text))
+	out = append(out, data[:start]...)
+	out = append(out, text...)
+	return append(out, data[end:]...)
+}
+
+func spansLines(data []byte, n *jsonNode) bool {
+	return bytes.IndexByte(data[n.start:n.end], '\n') >= 0
+}
+
+// lineIndent returns the whitespace that starts the line holding pos.
+func lineIndent(data []byte, pos int) string {
+	start := bytes.LastIndexByte(data[:pos], '\n') + 1
+	end := start
+	for end < pos && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[start:end])
+}
+
+// indentUnit guesses one level of indentation from the first indented line
+// of data, defaulting to two spaces.
+func indentUnit(data []byte) string {
+	for _, line := range bytes.Split(data, []byte("\n"))[1:] {
+		body := bytes.TrimLeft(line, " \t")
+		if len(body) > 0 && len(body) < len(line) {
+			return string(line[:len(line)-len(body)])
+		}
+	}
+	return "  "
+}
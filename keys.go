@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parsePointer splits a JSON pointer such as /oauthAccount/email into its
+// reference tokens. A numeric token such as the 0 in /users/0/name indexes
+// an array.
+func parsePointer(pointer string) ([]string, error) {
+	if !strings.HasPrefix(pointer, "/") || pointer == "/" {
+		return nil, fmt.Errorf("invalid key %q: must be a JSON pointer like /name", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, tok := range tokens {
+		if tok == "" {
+			return nil, fmt.Errorf("invalid key %q: empty segment", pointer)
+		}
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// validateKeys checks the format and key selectors of an app.
+func validateKeys(appConfig AppConfig) error {
+	codec, err := codecFor(configFormat(appConfig))
+	if err != nil {
+		return err
+	}
+	if len(appConfig.Keys) == 0 {
+		return nil
+	}
+	if isMultiPath(appConfig) {
+		return fmt.Errorf("keys cannot be used with paths")
+	}
+	if isFolder(expandPath(appConfig.AuthPath)) {
+		return fmt.Errorf("keys can only be used with a file, not the folder %s", expandPath(appConfig.AuthPath))
+	}
+	_, err = codec.pointerTokens(appConfig.Keys)
+	return err
+}
+
+// validateIgnoreKeys checks the ignored keys of an app.
+func validateIgnoreKeys(appConfig AppConfig) error {
+	for _, rule := range appConfig.IgnoreKeys {
+		if rule == "" {
+			return fmt.Errorf("invalid ignored key: empty")
+		}
+		if strings.HasPrefix(rule, "/") {
+			if _, err := parsePointer(rule); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func decodeJSONObject(data []byte) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return doc, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parse JSON: %w", err)
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	return doc, nil
+}
+
+func encodeJSONObject(doc map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// arrayIndex parses a pointer token as an array index.
+func arrayIndex(tok string) (int, bool) {
+	if tok == "" || len(tok) > 9 || (len(tok) > 1 && tok[0] == '0') {
+		return 0, false
+	}
+	n := 0
+	for _, c := range tok {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
+
+func lookupKey(doc map[string]interface{}, tokens []string) (interface{}, bool) {
+	var cur interface{} = doc
+	for _, tok := range tokens {
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if cur, ok = node[tok]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, ok := arrayIndex(tok)
+			if !ok || i >= len(node) {
+				return nil, false
+			}
+			cur = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// setKey stores value at tokens, creating objects along the way, or arrays
+// for numeric tokens. Values that are not containers are replaced where one
+// is needed, and arrays are padded with nulls up to the index.
+func setKey(doc map[string]interface{}, tokens []string, value interface{}) {
+	doc[tokens[0]] = setIn(doc[tokens[0]], tokens[1:], value)
+}
+
+// setIn returns cur with value stored at tokens below it.
+func setIn(cur interface{}, tokens []string, value interface{}) interface{} {
+	if len(tokens) == 0 {
+		return value
+	}
+	arr, isArr := cur.([]interface{})
+	if cur == nil {
+		isArr = true
+	}
+	if i, ok := arrayIndex(tokens[0]); ok && isArr {
+		for len(arr) <= i {
+			arr = append(arr, nil)
+		}
+		arr[i] = setIn(arr[i], tokens[1:], value)
+		return arr
+	}
+	obj, ok := cur.(map[string]interface{})
+	if !ok {
+		obj = map[string]interface{}{}
+	}
+	obj[tokens[0]] = setIn(obj[tokens[0]], tokens[1:], value)
+	return obj
+}
+
+// deleteKey removes the value at tokens. An array element is removed from
+// its array, moving the elements after it down.
+func deleteKey(doc map[string]interface{}, tokens []string) {
+	if len(tokens) == 1 {
+		delete(doc, tokens[0])
+		return
+	}
+	parent, ok := lookupKey(doc, tokens[:len(tokens)-1])
+	if !ok {
+		return
+	}
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		delete(node, last)
+	case []interface{}:
+		if i, ok := arrayIndex(last); ok && i < len(node) {
+			setKey(doc, tokens[:len(tokens)-1], append(node[:i:i], node[i+1:]...))
+		}
+	}
+}
+
+// selectKeys returns a document in format holding only the selected keys of
+// data.
+func selectKeys(data []byte, keys []string, format string) ([]byte, error) {
+	codec, err := codecFor(format)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := codec.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	pointers, err := codec.pointerTokens(keys)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{}
+	for _, tokens := range pointers {
+		if value, ok := lookupKey(doc, tokens); ok {
+			setKey(out, tokens, value)
+		}
+	}
+	return codec.encode(out)
+}
+
+// patchKeys copies the selected keys of profile into live. Keys missing from
+// profile are removed from live; everything else in live is kept.
+func patchKeys(live, profile []byte, keys []string, format string) ([]byte, error) {
+	codec, err := codecFor(format)
+	if err != nil {
+		return nil, err
+	}
+	pointers, err := codec.pointerTokens(keys)
+	if err != nil {
+		return nil, err
+	}
+	if codec.patch != nil {
+		out, err := codec.patch(live, profile, pointers)
+		if err != errKeepLayout {
+			return out, err
+		}
+	}
+	doc, err := codec.decode(live)
+	if err != nil {
+		return nil, fmt.Errorf("live config: %w", err)
+	}
+	stored, err := codec.decode(profile)
+	if err != nil {
+		return nil, fmt.Errorf("profile: %w", err)
+	}
+	for _, tokens := range pointers {
+		if value, ok := lookupKey(stored, tokens); ok {
+			setKey(doc, tokens, value)
+		} else {
+			deleteKey(doc, tokens)
+		}
+	}
+	return codec.encode(doc)
+}
+
+// keysEqual reports whether two documents in format agree on the selected
+// keys, leaving out the ignored ones.
+func keysEqual(a, b []byte, keys []string, format string, ignore []string) bool {
+	codec, err := codecFor(format)
+	if err != nil {
+		return false
+	}
+	pointers, err := codec.pointerTokens(keys)
+	if err != nil {
+		return false
+	}
+	aDoc, err := codec.decode(a)
+	if err != nil {
+		return false
+	}
+	bDoc, err := codec.decode(b)
+	if err != nil {
+		return false
+	}
+	stripIgnored(aDoc, ignore)
+	stripIgnored(bDoc, ignore)
+	for _, tokens := range pointers {
+		aValue, aOK := lookupKey(aDoc, tokens)
+		bValue, bOK := lookupKey(bDoc, tokens)
+		if aOK != bOK || !valuesEqual(aValue, bValue) {
+			return false
+		}
+	}
+	return true
+}
+
+// keysTransform returns the transform that patches a restored profile into
+// the live file at livePath.
+func keysTransform(livePath string, keys []string, format string) fileTransform {
+	return func(profile []byte) ([]byte, error) {
+		live, err := os.ReadFile(livePath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return patchKeys(live, profile, keys, format)
+	}
+}
+
+// chainTransforms applies first and then second; either may be nil.
+func chainTransforms(first, second fileTransform) fileTransform {
+	if first == nil {
+		return second
+	}
+	if second == nil {
+		return first
+	}
+	return func(data []byte) ([]byte, error) {
+		data, err := first(data)
+		if err != nil {
+			return nil, err
+		}
+		return second(data)
+	}
+}
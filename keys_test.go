@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePointer(t *testing.T) {
+	tokens, err := parsePointer("/a~1b/c~0d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[0] != "a/b" || tokens[1] != "c~d" {
+		t.Fatalf("unexpected tokens: %q", tokens)
+	}
+	for _, bad := range []string{"", "/", "name", "/a//b"} {
+		if _, err := parsePointer(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestSelectAndPatchKeys(t *testing.T) {
+	keys := []string{"/oauthAccount", "/auth/token", "/missing"}
+	live := []byte(`{"theme":"dark","oauthAccount":{"email":"a@x"},"auth":{"token":"t1","region":"eu"}}`)
+	picked, err := selectKeys(live, keys, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	json.Unmarshal(picked, &doc)
+	if _, ok := doc["theme"]; ok {
+		t.Fatalf("unselected key stored: %s", picked)
+	}
+	if auth := doc["auth"].(map[string]interface{}); auth["token"] != "t1" || auth["region"] != nil {
+		t.Fatalf("nested key not selected exactly: %s", picked)
+	}
+
+	other := []byte(`{"theme":"light","oauthAccount":{"email":"b@x"},"auth":{"token":"t2","region":"us"},"missing":1}`)
+	patched, err := patchKeys(other, picked, keys, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = nil
+	json.Unmarshal(patched, &doc)
+	if doc["theme"] != "light" || doc["auth"].(map[string]interface{})["region"] != "us" {
+		t.Fatalf("unselected values changed: %s", patched)
+	}
+	if doc["oauthAccount"].(map[string]interface{})["email"] != "a@x" || doc["auth"].(map[string]interface{})["token"] != "t1" {
+		t.Fatalf("selected values not patched: %s", patched)
+	}
+	if _, ok := doc["missing"]; ok {
+		t.Fatalf("key absent from profile not removed: %s", patched)
+	}
+
+	if !keysEqual(live, patched, keys, "json", nil) {
+		t.Fatalf("expected documents to agree on selected keys")
+	}
+	if keysEqual(live, other, keys, "json", nil) {
+		t.Fatalf("expected documents to differ on selected keys")
+	}
+}
+
+func TestKeys_SwitchKeepsOtherSettings(t *testing.T) {
+	home := setHome(t)
+	cfg := filepath.Join(home, ".claude", "config.json")
+	os.MkdirAll(filepath.Dir(cfg), 0755)
+	os.WriteFile(cfg, []byte(`{"theme":"dark","oauthAccount":{"email":"work@x"}}`), 0644)
+
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("claude", AppConfig{
+		Accounts:      []string{},
+		AuthPath:      "~/.claude/config.json",
+		SwitchPattern: "~/profiles/claude/{name}.json",
+		Keys:          []string{"/oauthAccount"},
+	})
+	if err := s.AddAccount("claude", "work"); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(cfg, []byte(`{"theme":"dark","oauthAccount":{"email":"home@x"}}`), 0644)
+	if err := s.AddAccount("claude", "home"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing an unrelated setting keeps the profile current
+	os.WriteFile(cfg, []byte(`{"theme":"light","oauthAccount":{"email":"home@x"}}`), 0644)
+	if cur := s.findCurrentAccount("claude"); cur != "home" {
+		t.Fatalf("expected current home, got %q", cur)
+	}
+
+	if err := s.SwitchAccount("claude", "work"); err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	b, _ := os.ReadFile(cfg)
+	json.Unmarshal(b, &doc)
+	if doc["theme"] != "light" {
+		t.Fatalf("unrelated setting clobbered: %s", b)
+	}
+	if doc["oauthAccount"].(map[string]interface{})["email"] != "work@x" {
+		t.Fatalf("account not switched: %s", b)
+	}
+	if cur := s.findCurrentAccount("claude"); cur != "work" {
+		t.Fatalf("expected current work, got %q", cur)
+	}
+}
+
+func TestValidateKeys(t *testing.T) {
+	home := setHome(t)
+	os.MkdirAll(filepath.Join(home, ".tool"), 0755)
+	cases := []AppConfig{
+		{AuthPath: "~/.claude.json", SwitchPattern: "~/p/{name}", Keys: []string{"oauthAccount"}},
+		{AuthPath: "~/.tool", SwitchPattern: "~/p/{name}", Keys: []string{"/token"}},
+		{SwitchPattern: "~/p/{name}", Paths: map[string]string{"a": "~/.a"}, Keys: []string{"/token"}},
+	}
+	for i, c := range cases {
+		if err := validateAppConfig(c); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestPatchKeys_KeepsJSONLayout(t *testing.T) {
+	live := []byte(`{
+    "zeta": "<keep & order>",
+    "auth": {
+        "token": "t1",
+        "region": "eu"
+    },
+    "accounts": [{"id": 1}, {"id": 2}],
+    "stale": true,
+    "alpha": 1.50
+}
+`)
+	profile := []byte(`{"auth":{"token":"t2"},"accounts":[{"id":1},{"id":3}],"new":{"nested":{"x":"<y>"}}}`)
+	keys := []string{"/auth/token", "/accounts/1/id", "/stale", "/new/nested", "/auth/missing"}
+	patched, err := patchKeys(live, profile, keys, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+    "zeta": "<keep & order>",
+    "auth": {
+        "token": "t2",
+        "region": "eu"
+    },
+    "accounts": [{"id": 1}, {"id": 3}],
+    "alpha": 1.50,
+    "new": {
+        "nested": {
+            "x": "<y>"
+        }
+    }
+}
+`
+	if string(patched) != want {
+		t.Fatalf("layout not kept:\n%s", patched)
+	}
+
+	// An empty object is filled in with the indentation of the file
+	patched, err = patchKeys([]byte("{\n\t\"a\": {}\n}\n"), []byte(`{"a":{"b":1}}`), []string{"/a/b"}, "json")
+	if err != nil || string(patched) != "{\n\t\"a\": {\n\t\t\"b\": 1\n\t}\n}\n" {
+		t.Fatalf("unexpected insert into empty object: %q %v", patched, err)
+	}
+	// A compact file stays compact, and the only member can be removed
+	patched, err = patchKeys([]byte(`{"a":1}`), []byte(`{"b":[2]}`), []string{"/a", "/b"}, "json")
+	if err != nil || string(patched) != `{"b":[2]}` {
+		t.Fatalf("unexpected compact patch: %q %v", patched, err)
+	}
+	// Values that cannot be edited in place fall back to rewriting the file
+	patched, err = patchKeys([]byte(`{"a": "scalar"}`), []byte(`{"a":{"b":1}}`), []string{"/a/b"}, "json")
+	if err != nil || !keysEqual(patched, []byte(`{"a":{"b":1}}`), []string{"/a"}, "json", nil) {
+		t.Fatalf("unexpected fallback patch: %q %v", patched, err)
+	}
+	if _, err := patchKeys([]byte(`{"a":`), profile, keys, "json"); err == nil {
+		t.Fatalf("expected parse error for broken live config")
+	}
+}
+
+func TestKeys_ArrayPointers(t *testing.T) {
+	live := []byte(`{"users":[{"name":"a","token":"t1"},{"name":"b","token":"t2"}]}`)
+	keys := []string{"/users/1/token"}
+	picked, err := selectKeys(live, keys, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := decodeJSONObject(picked)
+	if v, ok := lookupKey(doc, []string{"users", "1", "token"}); !ok || v != "t2" {
+		t.Fatalf("array element not selected: %s", picked)
+	}
+	if _, ok := lookupKey(doc, []string{"users", "0", "name"}); ok {
+		t.Fatalf("unselected element stored: %s", picked)
+	}
+	other := []byte(`{"users":[{"name":"a","token":"x"},{"name":"b","token":"y"}]}`)
+	patched, err := patchKeys(other, picked, keys, "json")
+	if err != nil || string(patched) != `{"users":[{"name":"a","token":"x"},{"name":"b","token":"t2"}]}` {
+		t.Fatalf("array element not patched: %s %v", patched, err)
+	}
+	if !keysEqual(live, patched, keys, "json", nil) || keysEqual(live, other, keys, "json", nil) {
+		t.Fatalf("array elements not compared")
+	}
+
+	doc, _ = decodeJSONObject(live)
+	deleteKey(doc, []string{"users", "0"})
+	if v, _ := lookupKey(doc, []string{"users", "0", "name"}); v != "b" {
+		t.Fatalf("array element not removed: %v", doc)
+	}
+	setKey(doc, []string{"users", "1", "name"}, "c")
+	if v, _ := lookupKey(doc, []string{"users", "1", "name"}); v != "c" {
+		t.Fatalf("array element not appended: %v", doc)
+	}
+}
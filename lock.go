@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockTimeout bounds how long NewSwitcher waits for another switch process
+// to release the config lock.
+var lockTimeout = 10 * time.Second
+
+const lockRetryInterval = 50 * time.Millisecond
+
+// configLock is an advisory lock on a sidecar file next to the config. It is
+// released automatically by the OS if the process dies.
+type configLock struct {
+	file *os.File
+}
+
+func acquireConfigLock(configPath string) (*configLock, error) {
+	file, err := os.OpenFile(configPath+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open config lock: %w", err)
+	}
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		ok, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("lock config: %w", err)
+		}
+		if ok {
+			return &configLock{file: file}, nil
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, fmt.Errorf("config is locked by another switch process: %s", file.Name())
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func (l *configLock) release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	err := unlockFile(l.file)
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+	return err
+}
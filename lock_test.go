@@ -0,0 +1,65 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigLock_BlocksConcurrentSwitcher(t *testing.T) {
+	home := setHome(t)
+	old := lockTimeout
+	lockTimeout = 200 * time.Millisecond
+	defer func() { lockTimeout = old }()
+
+	held, err := acquireConfigLock(filepath.Join(home, ".switch.toml"))
+	if err != nil {
+		t.Fatalf("acquireConfigLock: %v", err)
+	}
+	if _, err := NewSwitcher(); err == nil || !strings.Contains(err.Error(), "locked by another switch process") {
+		t.Fatalf("expected lock contention error, got %v", err)
+	}
+	if err := held.release(); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	s, err := NewSwitcher()
+	if err != nil {
+		t.Fatalf("NewSwitcher after release: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestConfigLock_WaitsForRelease(t *testing.T) {
+	home := setHome(t)
+	held, err := acquireConfigLock(filepath.Join(home, ".switch.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		held.release()
+	}()
+	start := time.Now()
+	s, err := NewSwitcher()
+	if err != nil {
+		t.Fatalf("NewSwitcher should wait for the lock: %v", err)
+	}
+	defer s.Close()
+	if time.Since(start) < 100*time.Millisecond {
+		t.Fatalf("NewSwitcher did not wait for the held lock")
+	}
+}
+
+func TestConfigLock_ReleaseNil(t *testing.T) {
+	var l *configLock
+	if err := l.release(); err != nil {
+		t.Fatalf("nil release: %v", err)
+	}
+	s := &Switcher{}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close without lock: %v", err)
+	}
+}
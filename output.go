@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ProfileStatus describes one stored profile in JSON output.
+type ProfileStatus struct {
+	Name     string `json:"name"`
+	Current  bool   `json:"current"`
+	Modified bool   `json:"modified"`
+	Path     string `json:"path"`
+	Exists   bool   `json:"exists"`
+}
+
+// AppStatus describes a configured application in JSON output. Current is
+// the profile the live config was loaded from; Modified is set when the live
+// config has changes that are not stored in any profile.
+type AppStatus struct {
+	Name          string            `json:"name"`
+	Default       bool              `json:"default"`
+	Current       string            `json:"current"`
+	Modified      bool              `json:"modified"`
+	AuthPath      string            `json:"auth_path"`
+	SwitchPattern string            `json:"switch_pattern"`
+	Paths         map[string]string `json:"paths,omitempty"`
+	Kind          string            `json:"kind"`
+	Encrypted     bool              `json:"encrypted"`
+	Locked        bool              `json:"locked,omitempty"`
+	Profiles      []ProfileStatus   `json:"profiles"`
+}
+
+// Result is the JSON document printed for every command in JSON mode.
+type Result struct {
+	Command string         `json:"command"`
+	OK      bool           `json:"ok"`
+	Error   string         `json:"error,omitempty"`
+	Version string         `json:"version,omitempty"`
+	Default string         `json:"default"`
+	Apps    []AppStatus    `json:"apps"`
+	History []HistoryEntry `json:"history,omitempty"`
+}
+
+func pathKind(path string) string {
+	switch {
+	case isFolder(path):
+		return "folder"
+	case fileOrDirExists(path):
+		return "file"
+	default:
+		return "missing"
+	}
+}
+
+// appStatus gathers the state of one configured application.
+func (s *Switcher) appStatus(appName string) AppStatus {
+	appConfig, _ := s.GetAppConfig(appName)
+	authPath := expandPath(appConfig.AuthPath)
+	current, locked := s.detectCurrent(appName)
+	drifted, origin := s.checkDrift(appName, current)
+	if drifted {
+		current = origin
+	}
+	status := AppStatus{
+		Name:          appName,
+		Default:       appName == s.config.Default.Config,
+		Current:       current,
+		Modified:      drifted,
+		AuthPath:      authPath,
+		SwitchPattern: appConfig.SwitchPattern,
+		Kind:          pathKind(authPath),
+		Encrypted:     appConfig.Encrypt,
+		Locked:        locked,
+		Profiles:      []ProfileStatus{},
+	}
+	if isMultiPath(appConfig) {
+		status.AuthPath = ""
+		status.Kind = "multi"
+		status.Paths = map[string]string{}
+		for _, lp := range livePaths(appConfig) {
+			status.Paths[lp.name] = lp.path
+		}
+	}
+	for _, acc := range appConfig.Accounts {
+		switchPath := resolveSwitchPattern(appConfig.SwitchPattern, appName, authPath, acc)
+		status.Profiles = append(status.Profiles, ProfileStatus{
+			Name:     acc,
+			Current:  acc == current,
+			Modified: drifted && acc == current,
+			Path:     switchPath,
+			Exists:   fileOrDirExists(switchPath),
+		})
+	}
+	return status
+}
+
+// ShowStatus prints the current profile and config path of one app, or of
+// all apps when appName is empty.
+func (s *Switcher) ShowStatus(appName string) error {
+	names := s.appNames()
+	if appName != "" {
+		if _, exists := s.GetAppConfig(appName); !exists {
+			return fmt.Errorf("app '%s' not found", appName)
+		}
+		names = []string{appName}
+	}
+	if len(names) == 0 {
+		fmt.Printf("%s✗ No applications configured%s\n", ColorRed, ColorReset)
+		return nil
+	}
+	for _, name := range names {
+		st := s.appStatus(name)
+		current := st.Current
+		if current == "" {
+			current = "-"
+		}
+		marker := ""
+		if st.Modified {
+			marker = fmt.Sprintf(" %s(modified)%s", ColorRed, ColorReset)
+		} else if st.Locked {
+			marker = fmt.Sprintf(" %s(locked)%s", ColorYellow, ColorReset)
+		}
+		location := st.AuthPath
+		if st.Kind == "multi" {
+			appConfig, _ := s.GetAppConfig(name)
+			var paths []string
+			for _, lp := range livePaths(appConfig) {
+				paths = append(paths, lp.name+"="+lp.path)
+			}
+			location = strings.Join(paths, " ")
+		}
+		fmt.Printf("%s%-10s%s %s%s  %s [%s]\n", ColorCyan, name, ColorReset, current, marker, location, st.Kind)
+	}
+	return nil
+}
+
+// jsonScope returns the command name and the apps whose state is reported
+// for a command line. A nil app list means all apps.
+func jsonScope(s *Switcher, args []string) (string, []string) {
+	if len(args) == 0 {
+		if s.config.Default.Config == "" {
+			return "cycle", []string{}
+		}
+		return "cycle", []string{s.config.Default.Config}
+	}
+	command := args[0]
+	rest := args[1:]
+	switch command {
+	case "add", "list", "status", "remove", "rm", "save", "encrypt", "migrate-store", "undo", "history", "default":
+		if (command == "save" || command == "encrypt") && len(rest) == 0 && s.config.Default.Config != "" {
+			return command, []string{s.config.Default.Config}
+		}
+		if len(rest) > 0 {
+			return command, []string{rest[0]}
+		}
+		return command, nil
+	case "rename", "mv":
+		if len(rest) == 2 {
+			return command, []string{rest[1]}
+		}
+		if len(rest) > 0 {
+			return command, []string{rest[0]}
+		}
+		return command, nil
+	case "context", "ctx":
+		if len(rest) == 1 {
+			if ctx, exists := s.config.Contexts[rest[0]]; exists {
+				return "context", contextApps(ctx)
+			}
+			return "context", []string{}
+		}
+		return "context", nil
+	case "version", "help", "config", "lock", "unlock", "templates":
+		return command, []string{}
+	}
+	// switch <app> [...]
+	return "switch", []string{command}
+}
+
+// runJSON runs a command with its human-readable output sent to stderr and
+// prints a Result describing the outcome and resulting state to stdout.
+func runJSON(args []string) int {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	lastError = nil
+	code := run(args)
+	os.Stdout = stdout
+
+	result := Result{OK: code == 0, Apps: []AppStatus{}}
+	if lastError != nil {
+		result.Error = lastError.Error()
+	} else if code != 0 {
+		result.Error = "command failed"
+	}
+
+	s, err := NewSwitcher()
+	if err != nil {
+		result.OK = false
+		result.Error = err.Error()
+	} else {
+		defer s.Close()
+		command, apps := jsonScope(s, args)
+		result.Command = command
+		result.Default = s.config.Default.Config
+		if command == "version" {
+			result.Version = shortVersion()
+		}
+		if apps == nil {
+			apps = s.appNames()
+		}
+		for _, name := range apps {
+			if _, exists := s.GetAppConfig(name); exists {
+				result.Apps = append(result.Apps, s.appStatus(name))
+			}
+		}
+		if command == "history" {
+			history, _ := s.loadHistory()
+			for _, e := range history {
+				if len(args) < 2 || e.App == args[1] {
+					result.History = append(result.History, e)
+				}
+			}
+		}
+	}
+
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+	return code
+}
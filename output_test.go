@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseGlobalFlags(t *testing.T) {
+	args, format, err := parseGlobalFlags([]string{"list", "--json", "codex"})
+	if err != nil || format != "json" || strings.Join(args, " ") != "list codex" {
+		t.Fatalf("--json parse: %v %q %v", args, format, err)
+	}
+	args, format, err = parseGlobalFlags([]string{"--format", "json", "status"})
+	if err != nil || format != "json" || strings.Join(args, " ") != "status" {
+		t.Fatalf("--format parse: %v %q %v", args, format, err)
+	}
+	_, format, err = parseGlobalFlags([]string{"--format=text", "list"})
+	if err != nil || format != "text" {
+		t.Fatalf("--format= parse: %q %v", format, err)
+	}
+	if _, _, err := parseGlobalFlags([]string{"--format=yaml"}); err == nil {
+		t.Fatalf("expected unknown format error")
+	}
+	if _, _, err := parseGlobalFlags([]string{"list", "--format"}); err == nil {
+		t.Fatalf("expected missing value error")
+	}
+}
+
+func seedJSONApps(t *testing.T) *Switcher {
+	t.Helper()
+	home := setHome(t)
+	setupCodexFiles(t, home, `{"token":"b"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "b", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	s.SetAppConfig("zed", AppConfig{Current: "", Accounts: []string{}, AuthPath: "~/.zed/settings.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	s.SetAppConfig("aider", AppConfig{Current: "", Accounts: []string{}, AuthPath: "~/.aider.conf", SwitchPattern: "{auth_path}.{name}.switch"})
+	s.config.Default.Config = "codex"
+	if err := s.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func runJSONCaptured(t *testing.T, args ...string) (int, Result) {
+	t.Helper()
+	var code int
+	out, _ := captureOutput(t, func() { code = runJSON(args) })
+	var res Result
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("invalid JSON output %q: %v", out, err)
+	}
+	return code, res
+}
+
+func TestRunJSON_List(t *testing.T) {
+	seedJSONApps(t)
+	code, res := runJSONCaptured(t, "list")
+	if code != 0 || !res.OK || res.Command != "list" || res.Default != "codex" {
+		t.Fatalf("unexpected result: code=%d %+v", code, res)
+	}
+	var names []string
+	for _, app := range res.Apps {
+		names = append(names, app.Name)
+	}
+	if strings.Join(names, ",") != "aider,codex,zed" {
+		t.Fatalf("apps not sorted: %v", names)
+	}
+	codex := res.Apps[1]
+	if !codex.Default || codex.Current != "b" || codex.Kind != "file" || len(codex.Profiles) != 2 {
+		t.Fatalf("unexpected codex status: %+v", codex)
+	}
+	if !codex.Profiles[1].Current || codex.Profiles[0].Current || !codex.Profiles[0].Exists {
+		t.Fatalf("unexpected profile markers: %+v", codex.Profiles)
+	}
+	// App scoped read
+	_, res2 := runJSONCaptured(t, "status", "codex")
+	if len(res2.Apps) != 1 || res2.Apps[0].Name != "codex" {
+		t.Fatalf("expected only codex in status: %+v", res2.Apps)
+	}
+}
+
+func TestRunJSON_SwitchAndErrors(t *testing.T) {
+	seedJSONApps(t)
+	code, res := runJSONCaptured(t, "codex", "a")
+	if code != 0 || !res.OK || res.Command != "switch" || len(res.Apps) != 1 || res.Apps[0].Current != "a" {
+		t.Fatalf("unexpected switch result: code=%d %+v", code, res)
+	}
+	code, res = runJSONCaptured(t, "codex", "ghost")
+	if code != 1 || res.OK || !strings.Contains(res.Error, "not found") {
+		t.Fatalf("expected error result: code=%d %+v", code, res)
+	}
+	_, res = runJSONCaptured(t, "history", "codex")
+	if len(res.History) != 1 || res.History[0].To != "a" {
+		t.Fatalf("expected history in JSON: %+v", res.History)
+	}
+	_, res = runJSONCaptured(t, "version")
+	if res.Version == "" || len(res.Apps) != 0 {
+		t.Fatalf("unexpected version result: %+v", res)
+	}
+}
+
+func TestListAllApps_Sorted(t *testing.T) {
+	s := seedJSONApps(t)
+	out, _ := captureOutput(t, func() { s.ListAllApps() })
+	a, c, z := strings.Index(out, "aider"), strings.Index(out, "codex"), strings.Index(out, "zed")
+	if !(a < c && c < z) {
+		t.Fatalf("apps not listed in sorted order: %q", out)
+	}
+}
+
+func TestShowStatus(t *testing.T) {
+	s := seedJSONApps(t)
+	out, _ := captureOutput(t, func() {
+		if code := handleStatus(s, nil); code != 0 {
+			t.Fatalf("status failed: %d", code)
+		}
+	})
+	if !strings.Contains(out, "codex") || !strings.Contains(out, "[file]") || !strings.Contains(out, "[missing]") {
+		t.Fatalf("unexpected status output: %q", out)
+	}
+	if code := handleStatus(s, []string{"nosuch"}); code != 1 {
+		t.Fatalf("expected error for unknown app")
+	}
+	if code := handleStatus(s, []string{"a", "b"}); code != 1 {
+		t.Fatalf("expected usage error")
+	}
+}
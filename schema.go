@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+)
+
+// configSchemaVersion is the config layout written by this version. Configs
+// without schema_version are version 0, which includes the old layout that
+// kept each app in a top-level table such as [codex].
+const configSchemaVersion = 1
+
+// configTables are the top-level names of the current config layout.
+var configTables = []string{"schema_version", "default", "apps", "contexts", "templates", "encryption"}
+
+// legacyApps returns the apps that a version 0 config keeps in top-level
+// tables.
+func legacyApps(data []byte) (map[string]AppConfig, error) {
+	var raw map[string]toml.Primitive
+	md, err := toml.Decode(string(data), &raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	apps := make(map[string]AppConfig)
+	for name, prim := range raw {
+		if contains(configTables, name) || md.Type(name) != "Hash" {
+			continue
+		}
+		var app AppConfig
+		if err := md.PrimitiveDecode(prim, &app); err != nil {
+			return nil, fmt.Errorf("parse app [%s]: %w", name, err)
+		}
+		apps[name] = app
+	}
+	return apps, nil
+}
+
+// migrateConfig brings a config read from data up to configSchemaVersion.
+// The original file is kept as <config>.v<version>.bak before the migrated
+// config is written. Configs from a newer version are left as they are.
+func (s *Switcher) migrateConfig(data []byte) error {
+	from := s.config.SchemaVersion
+	if from >= configSchemaVersion {
+		return nil
+	}
+
+	var moved []string
+	if from == 0 {
+		apps, err := legacyApps(data)
+		if err != nil {
+			return err
+		}
+		for name, app := range apps {
+			if _, exists := s.config.Apps[name]; exists {
+				return fmt.Errorf("app %s is defined both as [%s] and [apps.%s]", name, name, name)
+			}
+			s.config.Apps[name] = app
+			moved = append(moved, name)
+		}
+		sort.Strings(moved)
+	}
+
+	backup := fmt.Sprintf("%s.v%d.bak", s.configPath, from)
+	if err := writeFileAtomic(backup, data, 0600); err != nil {
+		return fmt.Errorf("back up config: %w", err)
+	}
+	s.config.SchemaVersion = configSchemaVersion
+	if err := s.saveConfig(); err != nil {
+		return err
+	}
+	if len(moved) > 0 {
+		fmt.Fprintf(os.Stderr, "%s✓ Moved %d app(s) to [apps] in %s; the old config is saved as %s%s\n",
+			ColorGreen, len(moved), s.configPath, backup, ColorReset)
+	}
+	return nil
+}
+
+// checkSchemaVersion refuses to write a config from a newer version, which
+// would drop the settings this version does not know.
+func (s *Switcher) checkSchemaVersion() error {
+	if s.config.SchemaVersion > configSchemaVersion {
+		return fmt.Errorf("config %s has schema version %d, but this version of switch supports up to %d: upgrade switch to change it",
+			s.configPath, s.config.SchemaVersion, configSchemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const legacyConfig = `[default]
+  config = "codex"
+
+[codex]
+  current = "work"
+  accounts = ["work", "personal"]
+  auth_path = "~/.codex/auth.json"
+  switch_pattern = "{auth_path}.{name}.switch"
+
+[vscode]
+  current = "dev"
+  accounts = ["dev"]
+  auth_path = "~/.vscode/User"
+  switch_pattern = "~/.vscode/profiles/{name}.switch"
+`
+
+func TestLoadConfig_MigratesLegacyLayout(t *testing.T) {
+	home := setHome(t)
+	path := filepath.Join(home, ".switch.toml")
+	os.WriteFile(path, []byte(legacyConfig), 0644)
+
+	var s *Switcher
+	_, stderr := captureOutput(t, func() {
+		var err error
+		if s, err = newTestSwitcher(t, home); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if app, ok := s.GetAppConfig("codex"); !ok || app.Current != "work" || len(app.Accounts) != 2 {
+		t.Fatalf("legacy codex app not migrated: %+v", app)
+	}
+	if app, ok := s.GetAppConfig("vscode"); !ok || app.AuthPath != "~/.vscode/User" {
+		t.Fatalf("legacy vscode app not migrated: %+v", app)
+	}
+	if !strings.Contains(stderr, "Moved 2 app(s)") {
+		t.Fatalf("migration not reported: %q", stderr)
+	}
+	if b, _ := os.ReadFile(path + ".v0.bak"); string(b) != legacyConfig {
+		t.Fatalf("original config not backed up: %q", b)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "schema_version = 1") || !strings.Contains(string(data), "[apps.codex]") || strings.Contains(string(data), "\n[codex]") {
+		t.Fatalf("unexpected migrated config:\n%s", data)
+	}
+
+	_, stderr = captureOutput(t, func() {
+		if _, err := newTestSwitcher(t, home); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if stderr != "" {
+		t.Fatalf("migrated config migrated again: %q", stderr)
+	}
+}
+
+func TestLoadConfig_StampsSchemaVersion(t *testing.T) {
+	home := setHome(t)
+	path := filepath.Join(home, ".switch.toml")
+	old := "[apps.codex]\n  current = \"a\"\n  accounts = [\"a\"]\n  auth_path = \"~/.codex/auth.json\"\n  switch_pattern = \"{auth_path}.{name}.switch\"\n"
+	os.WriteFile(path, []byte(old), 0644)
+	s, err := newTestSwitcher(t, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.config.SchemaVersion != configSchemaVersion {
+		t.Fatalf("schema version not set: %d", s.config.SchemaVersion)
+	}
+	if _, ok := s.GetAppConfig("codex"); !ok {
+		t.Fatalf("app lost")
+	}
+	if b, _ := os.ReadFile(path + ".v0.bak"); string(b) != old {
+		t.Fatalf("original config not backed up: %q", b)
+	}
+}
+
+func TestLoadConfig_LegacyConflict(t *testing.T) {
+	home := setHome(t)
+	path := filepath.Join(home, ".switch.toml")
+	data := legacyConfig + "\n[apps.codex]\n  auth_path = \"~/.codex/auth.json\"\n"
+	os.WriteFile(path, []byte(data), 0644)
+	if _, err := newTestSwitcher(t, home); err == nil || !strings.Contains(err.Error(), "defined both") {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != data {
+		t.Fatalf("config changed despite conflict")
+	}
+}
+
+func TestSaveConfig_RefusesNewerSchema(t *testing.T) {
+	home := setHome(t)
+	path := filepath.Join(home, ".switch.toml")
+	data := "schema_version = 99\n\n[apps.codex]\n  current = \"a\"\n  accounts = [\"a\"]\n  auth_path = \"~/.codex/auth.json\"\n  switch_pattern = \"~/p/{name}\"\n  future_setting = true\n"
+	os.WriteFile(path, []byte(data), 0644)
+	s, err := newTestSwitcher(t, home)
+	if err != nil {
+		t.Fatalf("newer config should still load: %v", err)
+	}
+	if _, ok := s.GetAppConfig("codex"); !ok {
+		t.Fatalf("newer config not read")
+	}
+	if err := s.saveConfig(); err == nil || !strings.Contains(err.Error(), "upgrade switch") {
+		t.Fatalf("expected downgrade to be refused, got %v", err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != data {
+		t.Fatalf("newer config was rewritten")
+	}
+	if fileOrDirExists(path + ".v99.bak") {
+		t.Fatalf("newer config should not be backed up")
+	}
+}
+
+func TestSwitchAccount_NewerSchemaLeavesLiveConfig(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	path := filepath.Join(home, ".switch.toml")
+	data := "schema_version = 99\n\n[apps.codex]\n  current = \"a\"\n  accounts = [\"a\", \"b\"]\n  auth_path = \"~/.codex/auth.json\"\n  switch_pattern = \"{auth_path}.{name}.switch\"\n"
+	os.WriteFile(path, []byte(data), 0644)
+	s, err := newTestSwitcher(t, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SwitchAccount("codex", "b"); err == nil || !strings.Contains(err.Error(), "upgrade switch") {
+		t.Fatalf("expected switch to be refused, got %v", err)
+	}
+	if b, _ := os.ReadFile(authPath); string(b) != `{"token":"a"}` {
+		t.Fatalf("live config changed: %s", b)
+	}
+	if entries, _ := s.loadHistory(); len(entries) != 0 {
+		t.Fatalf("switch recorded: %+v", entries)
+	}
+	if b, _ := os.ReadFile(path); string(b) != data {
+		t.Fatalf("newer config was rewritten")
+	}
+}
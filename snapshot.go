@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// livePath is one live config location of an app. Apps with a single auth
+// path have one livePath with an empty name; apps with paths have one per
+// entry, stored under its name inside the snapshot folder.
+type livePath struct {
+	name string
+	path string
+}
+
+// livePaths returns the live config locations of an app, sorted by name.
+func livePaths(appConfig AppConfig) []livePath {
+	if !isMultiPath(appConfig) {
+		return []livePath{{path: expandPath(appConfig.AuthPath)}}
+	}
+	var out []livePath
+	for name, p := range appConfig.Paths {
+		out = append(out, livePath{name: name, path: expandPath(p)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out
+}
+
+// isMultiPath reports whether an app declares several named paths instead of
+// a single auth path.
+func isMultiPath(appConfig AppConfig) bool {
+	return len(appConfig.Paths) > 0
+}
+
+// in returns where lp is stored inside the snapshot at snapshot.
+func (lp livePath) in(snapshot string) string {
+	if lp.name == "" {
+		return snapshot
+	}
+	return filepath.Join(snapshot, lp.name)
+}
+
+// liveExists reports whether any live config of an app exists.
+func liveExists(appConfig AppConfig) bool {
+	for _, lp := range livePaths(appConfig) {
+		if fileOrDirExists(lp.path) {
+			return true
+		}
+	}
+	return false
+}
+
+// liveLabel returns the live config locations of an app joined by sep, for
+// messages and hook environments.
+func liveLabel(appConfig AppConfig, sep string) string {
+	var paths []string
+	for _, lp := range livePaths(appConfig) {
+		paths = append(paths, lp.path)
+	}
+	return strings.Join(paths, sep)
+}
+
+// liveBase names the copy of an app's live config in the state directory.
+func liveBase(appConfig AppConfig) string {
+	if isMultiPath(appConfig) {
+		return "paths"
+	}
+	return filepath.Base(expandPath(appConfig.AuthPath))
+}
+
+// recoverLive cleans up switches of an app that were interrupted.
+func recoverLive(appConfig AppConfig) error {
+	for _, lp := range livePaths(appConfig) {
+		if err := recoverSwap(lp.path, profileFilter(appConfig, lp.path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSnapshot atomically stores the live config of an app as the snapshot
+// dst, encrypting it if the app asks for that. Apps with keys store only
+// those values.
+func (s *Switcher) writeSnapshot(appConfig AppConfig, dst string) error {
+	transform, err := s.sealTransform(appConfig)
+	if err != nil {
+		return err
+	}
+	if !isMultiPath(appConfig) {
+		src := expandPath(appConfig.AuthPath)
+		if len(appConfig.Keys) > 0 {
+			pick := func(data []byte) ([]byte, error) { return selectKeys(data, appConfig.Keys, configFormat(appConfig)) }
+			transform = chainTransforms(pick, transform)
+		}
+		return replacePathWith(src, dst, copyOptions{transform: transform, filter: profileFilter(appConfig, src)})
+	}
+	if !liveExists(appConfig) {
+		return fmt.Errorf("none of the paths exist: %s", liveLabel(appConfig, ", "))
+	}
+	dst = resolveLink(dst)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	stage, err := os.MkdirTemp(filepath.Dir(dst), stagePrefix(dst))
+	if err != nil {
+		return err
+	}
+	if err := s.copyLive(appConfig, stage, transform); err != nil {
+		os.RemoveAll(stage)
+		return err
+	}
+	if err := swapInto(stage, dst, nil); err != nil {
+		os.RemoveAll(stage)
+		return err
+	}
+	syncDir(filepath.Dir(dst))
+	return nil
+}
+
+// copyLive copies every existing live path of a multi-path app into dst.
+func (s *Switcher) copyLive(appConfig AppConfig, dst string, transform fileTransform) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, lp := range livePaths(appConfig) {
+		if !fileOrDirExists(lp.path) {
+			continue
+		}
+		opts := copyOptions{transform: transform, filter: profileFilter(appConfig, lp.path)}
+		if err := copyPathWith(lp.path, lp.in(dst), opts); err != nil {
+			return fmt.Errorf("%s: %w", lp.name, err)
+		}
+	}
+	return nil
+}
+
+// restoreSnapshot replaces the live config of an app with the snapshot src,
+// decrypting it if needed. Entries of the live folders that the app does not
+// manage are carried over, and apps with keys only have those values
+// patched. For multi-path apps every path is staged before any is replaced,
+// and a failed swap puts back the paths already replaced. Paths missing from
+// the snapshot are removed, as they are not part of the profile.
+func (s *Switcher) restoreSnapshot(appConfig AppConfig, src string) error {
+	// dst is the live path with symlinks resolved, so a linked config is
+	// replaced next to its target and stays linked.
+	type staged struct {
+		dst   string
+		stage string
+		keep  []string
+	}
+	var stages []staged
+	cleanup := func() {
+		for _, st := range stages {
+			os.RemoveAll(st.stage)
+		}
+	}
+	for _, lp := range livePaths(appConfig) {
+		from := lp.in(src)
+		dst := resolveLink(lp.path)
+		if lp.name != "" && !fileOrDirExists(from) {
+			// An empty stage removes the path when it is swapped.
+			if _, err := os.Lstat(dst); err == nil {
+				stages = append(stages, staged{dst, "", profileFilter(appConfig, dst).unmanaged(dst)})
+			}
+			continue
+		}
+		transform, err := s.openTransform(from)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		if len(appConfig.Keys) > 0 {
+			transform = chainTransforms(transform, keysTransform(lp.path, appConfig.Keys, configFormat(appConfig)))
+		}
+		filter := profileFilter(appConfig, dst)
+		stage, err := stageCopy(from, dst, copyOptions{transform: transform, filter: filter})
+		if err != nil {
+			cleanup()
+			return err
+		}
+		stages = append(stages, staged{dst, stage, filter.unmanaged(dst)})
+	}
+	if len(stages) == 1 && stages[0].stage != "" {
+		st := stages[0]
+		if err := swapInto(st.stage, st.dst, st.keep); err != nil {
+			cleanup()
+			return err
+		}
+		syncDir(filepath.Dir(st.dst))
+		return nil
+	}
+	// Swap every path in before finishing any, so that if one fails the
+	// originals of the paths already swapped can be put back.
+	asides := make([]string, len(stages))
+	for i, st := range stages {
+		aside, err := swapAside(st.stage, st.dst)
+		if err != nil {
+			for _, rest := range stages[i:] {
+				os.RemoveAll(rest.stage)
+			}
+			for j := i - 1; j >= 0; j-- {
+				if berr := swapBack(asides[j], stages[j].dst); berr != nil {
+					err = fmt.Errorf("%w; restore %s: %v", err, stages[j].dst, berr)
+				}
+			}
+			return err
+		}
+		asides[i] = aside
+	}
+	for i, st := range stages {
+		if asides[i] != "" {
+			if err := finishSwap(asides[i], st.dst, st.keep); err != nil {
+				return err
+			}
+		}
+		syncDir(filepath.Dir(st.dst))
+	}
+	return nil
+}
+
+// copyOut copies the live config into the state directory, for stashes and
+// undo copies, encrypting it if the app asks for that.
+func (s *Switcher) copyOut(appConfig AppConfig, dst string) error {
+	transform, err := s.sealTransform(appConfig)
+	if err != nil {
+		return err
+	}
+	if isMultiPath(appConfig) {
+		return s.copyLive(appConfig, dst, transform)
+	}
+	src := expandPath(appConfig.AuthPath)
+	return copyPathWith(src, dst, copyOptions{transform: transform, filter: profileFilter(appConfig, src)})
+}
+
+// snapshotEqual compares the live config of an app with a snapshot. For
+// multi-path apps every path must match, and a path missing from the
+// snapshot must hold nothing the app manages, as restoreSnapshot removes it.
+// known is false when an encrypted snapshot cannot be compared because no
+// key is cached.
+func (s *Switcher) snapshotEqual(appConfig AppConfig, snapshot string) (equal, known bool) {
+	for _, lp := range livePaths(appConfig) {
+		stored := lp.in(snapshot)
+		if lp.name != "" && !fileOrDirExists(stored) {
+			if !liveEmpty(lp.path, profileFilter(appConfig, lp.path)) {
+				return false, true
+			}
+			continue
+		}
+		if lp.name != "" && !fileOrDirExists(lp.path) {
+			return false, true
+		}
+		equal, known := s.pathEqual(lp.path, stored, profileFilter(appConfig, lp.path))
+		if !known || !equal {
+			return equal, known
+		}
+	}
+	return true, true
+}
+
+// liveEmpty reports whether a live path holds nothing that filter manages:
+// it is missing, or a folder left with only unmanaged entries after
+// restoreSnapshot removed it.
+func liveEmpty(path string, filter pathFilter) bool {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return true
+	}
+	if !isFolder(path) {
+		return false
+	}
+	manifest, err := folderManifest(path, filter, nil)
+	if err != nil {
+		return false
+	}
+	for _, entry := range manifest {
+		if !entry.mode.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// pathEqual compares one live path with its stored copy on the entries that
+// filter manages. Encrypted copies are decrypted in memory, so no plaintext
+// is written to disk.
+func (s *Switcher) pathEqual(live, snapshot string, filter pathFilter) (equal, known bool) {
+	if !pathSealed(snapshot) {
+		return contentEqualWith(live, snapshot, filter, nil), true
+	}
+	key := s.cachedKey()
+	if key == nil {
+		return false, false
+	}
+	open := func(data []byte) ([]byte, error) { return openData(key, data) }
+	return contentEqualWith(live, snapshot, filter, open), true
+}
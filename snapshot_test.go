@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setupMultiPathApp(t *testing.T, home string) (*Switcher, string, string) {
+	t.Helper()
+	token := filepath.Join(home, ".tool", "token")
+	settings := filepath.Join(home, ".config", "tool")
+	os.MkdirAll(filepath.Dir(token), 0755)
+	os.MkdirAll(settings, 0755)
+	os.WriteFile(token, []byte("work-token"), 0600)
+	os.WriteFile(filepath.Join(settings, "settings.json"), []byte(`{"org":"work"}`), 0644)
+
+	s, err := newTestSwitcher(t, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetAppConfig("tool", AppConfig{
+		Accounts:      []string{},
+		SwitchPattern: "~/profiles/tool/{name}",
+		Paths:         map[string]string{"token": "~/.tool/token", "settings": "~/.config/tool"},
+	})
+	return s, token, settings
+}
+
+func TestMultiPath_AddSwitchDetect(t *testing.T) {
+	home := setHome(t)
+	s, token, settings := setupMultiPathApp(t, home)
+	if err := s.AddAccount("tool", "work"); err != nil {
+		t.Fatal(err)
+	}
+	snap := filepath.Join(home, "profiles", "tool", "work")
+	if b, _ := os.ReadFile(filepath.Join(snap, "token")); string(b) != "work-token" {
+		t.Fatalf("token not stored in snapshot: %q", b)
+	}
+	if b, _ := os.ReadFile(filepath.Join(snap, "settings", "settings.json")); string(b) != `{"org":"work"}` {
+		t.Fatalf("settings not stored in snapshot: %q", b)
+	}
+
+	os.WriteFile(token, []byte("home-token"), 0600)
+	os.WriteFile(filepath.Join(settings, "settings.json"), []byte(`{"org":"home"}`), 0644)
+	if err := s.AddAccount("tool", "home"); err != nil {
+		t.Fatal(err)
+	}
+	if cur := s.findCurrentAccount("tool"); cur != "home" {
+		t.Fatalf("expected current home, got %q", cur)
+	}
+
+	// One path differing is enough to not match any profile
+	os.WriteFile(token, []byte("work-token"), 0600)
+	if cur := s.findCurrentAccount("tool"); cur != "" {
+		t.Fatalf("expected no match with mixed paths, got %q", cur)
+	}
+	os.WriteFile(token, []byte("home-token"), 0600)
+
+	if err := s.SwitchAccount("tool", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(token); string(b) != "work-token" {
+		t.Fatalf("token not switched: %q", b)
+	}
+	if b, _ := os.ReadFile(filepath.Join(settings, "settings.json")); string(b) != `{"org":"work"}` {
+		t.Fatalf("settings not switched: %q", b)
+	}
+	if cur := s.findCurrentAccount("tool"); cur != "work" {
+		t.Fatalf("expected current work, got %q", cur)
+	}
+
+	if err := s.Undo("tool"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(token); string(b) != "home-token" {
+		t.Fatalf("undo did not restore token: %q", b)
+	}
+	if cur := s.findCurrentAccount("tool"); cur != "home" {
+		t.Fatalf("expected current home after undo, got %q", cur)
+	}
+}
+
+func TestMultiPath_MissingPath(t *testing.T) {
+	home := setHome(t)
+	s, token, settings := setupMultiPathApp(t, home)
+	os.Remove(token)
+	if err := s.AddAccount("tool", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if fileOrDirExists(filepath.Join(home, "profiles", "tool", "work", "token")) {
+		t.Fatalf("missing path stored in snapshot")
+	}
+	if cur := s.findCurrentAccount("tool"); cur != "work" {
+		t.Fatalf("expected current work, got %q", cur)
+	}
+
+	// A path that appears later is not part of the profile, so it no longer
+	// matches
+	os.WriteFile(token, []byte("home-token"), 0600)
+	if cur := s.findCurrentAccount("tool"); cur != "" {
+		t.Fatalf("expected no match with a path the profile does not hold, got %q", cur)
+	}
+	os.WriteFile(filepath.Join(settings, "settings.json"), []byte(`{"org":"home"}`), 0644)
+	if err := s.AddAccount("tool", "home"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Paths missing from the snapshot are removed on switch
+	if err := s.SwitchAccount("tool", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if fileOrDirExists(token) {
+		t.Fatalf("path missing from profile was left live")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(token))
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".switch-") {
+			t.Fatalf("left behind %s", e.Name())
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(settings, "settings.json")); string(b) != `{"org":"work"}` {
+		t.Fatalf("settings not switched: %q", b)
+	}
+	if cur := s.findCurrentAccount("tool"); cur != "work" {
+		t.Fatalf("expected current work after switch, got %q", cur)
+	}
+	// Undo puts the removed path back
+	if err := s.Undo("tool"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(token); string(b) != "home-token" {
+		t.Fatalf("undo did not restore the removed path: %q", b)
+	}
+	if err := s.SwitchAccount("tool", "work"); err != nil {
+		t.Fatal(err)
+	}
+	// Switching back restores the path
+	if err := s.SwitchAccount("tool", "home"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(token); string(b) != "home-token" {
+		t.Fatalf("path not restored: %q", b)
+	}
+	// A path the profile holds must exist to match
+	os.Remove(token)
+	if cur := s.findCurrentAccount("tool"); cur == "home" {
+		t.Fatalf("profile matched with a stored path missing")
+	}
+
+	os.Remove(token)
+	os.RemoveAll(settings)
+	if err := s.AddAccount("tool", "none"); err == nil {
+		t.Fatalf("expected error when no path exists")
+	}
+}
+
+func TestMultiPath_Preview(t *testing.T) {
+	home := setHome(t)
+	s, _, settings := setupMultiPathApp(t, home)
+	if err := s.AddAccount("tool", "work"); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(settings, "extra.json"), []byte("x"), 0644)
+
+	out, _ := captureOutput(t, func() {
+		if err := s.PreviewSwitch("tool", "work"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, want := range []string{"Replace " + settings, "Replace " + filepath.Join(home, ".tool", "token"), "settings/extra.json"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("dry run missing %q: %q", want, out)
+		}
+	}
+}
+
+func TestValidatePaths(t *testing.T) {
+	setHome(t)
+	cases := []struct {
+		name   string
+		config AppConfig
+	}{
+		{"both set", AppConfig{AuthPath: "~/.tool", SwitchPattern: "~/p/{name}", Paths: map[string]string{"a": "~/.a"}}},
+		{"auth_path placeholder", AppConfig{SwitchPattern: "{auth_path}.{name}", Paths: map[string]string{"a": "~/.a"}}},
+		{"bad name", AppConfig{SwitchPattern: "~/p/{name}", Paths: map[string]string{"a/b": "~/.a"}}},
+		{"empty path", AppConfig{SwitchPattern: "~/p/{name}", Paths: map[string]string{"a": ""}}},
+		{"profile around path", AppConfig{SwitchPattern: "~/.a/{name}", Paths: map[string]string{"a": "~/.a/profile/token", "b": "~/.b"}}},
+	}
+	for _, tc := range cases {
+		if err := validateAppConfig(tc.config); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+	ok := AppConfig{SwitchPattern: "~/p/{name}", Paths: map[string]string{"a": "~/.a", "b": "~/.b"}}
+	if err := validateAppConfig(ok); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMultiPath_RestoreRollsBack(t *testing.T) {
+	home := setHome(t)
+	s, token, settings := setupMultiPathApp(t, home)
+	if err := s.AddAccount("tool", "work"); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(token, []byte("home-token"), 0600)
+	os.WriteFile(filepath.Join(settings, "settings.json"), []byte(`{"org":"home"}`), 0644)
+	os.WriteFile(filepath.Join(settings, "cache"), []byte("x"), 0644)
+	if err := s.AddAccount("tool", "home"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Paths are swapped in name order, so settings is replaced before the
+	// token swap fails.
+	old := renamePath
+	renamePath = func(from, to string) error {
+		if to == token {
+			return errors.New("rename failed")
+		}
+		return old(from, to)
+	}
+	t.Cleanup(func() { renamePath = old })
+	snapshot := filepath.Join(home, "profiles", "tool", "work")
+	if err := s.restoreSnapshot(s.config.Apps["tool"], snapshot); err == nil {
+		t.Fatalf("expected swap error")
+	}
+	if b, _ := os.ReadFile(filepath.Join(settings, "settings.json")); string(b) != `{"org":"home"}` {
+		t.Fatalf("settings not rolled back: %q", b)
+	}
+	if !fileOrDirExists(filepath.Join(settings, "cache")) {
+		t.Fatalf("settings entries lost in rollback")
+	}
+	if b, _ := os.ReadFile(token); string(b) != "home-token" {
+		t.Fatalf("token changed: %q", b)
+	}
+	for _, dir := range []string{filepath.Dir(token), filepath.Dir(settings)} {
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 1 {
+			t.Fatalf("stage or aside left in %s: %v", dir, entries)
+		}
+	}
+
+	renamePath = old
+	if err := s.restoreSnapshot(s.config.Apps["tool"], snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(token); string(b) != "work-token" {
+		t.Fatalf("token not switched: %q", b)
+	}
+}
+
+func TestMultiPath_RemovalRollsBack(t *testing.T) {
+	home := setHome(t)
+	s, token, settings := setupMultiPathApp(t, home)
+	os.Remove(token)
+	if err := s.AddAccount("tool", "bare"); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(token, []byte("home-token"), 0600)
+	os.WriteFile(filepath.Join(settings, "settings.json"), []byte(`{"org":"home"}`), 0644)
+
+	// settings is swapped before removing the token fails.
+	old := renamePath
+	renamePath = func(from, to string) error {
+		if from == token {
+			return errors.New("rename failed")
+		}
+		return old(from, to)
+	}
+	t.Cleanup(func() { renamePath = old })
+	snapshot := filepath.Join(home, "profiles", "tool", "bare")
+	if err := s.restoreSnapshot(s.config.Apps["tool"], snapshot); err == nil {
+		t.Fatalf("expected swap error")
+	}
+	if b, _ := os.ReadFile(filepath.Join(settings, "settings.json")); string(b) != `{"org":"home"}` {
+		t.Fatalf("settings not rolled back: %q", b)
+	}
+	if b, _ := os.ReadFile(token); string(b) != "home-token" {
+		t.Fatalf("token changed: %q", b)
+	}
+
+	renamePath = old
+	if err := s.restoreSnapshot(s.config.Apps["tool"], snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if fileOrDirExists(token) {
+		t.Fatalf("token not removed")
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(token)); len(entries) != 0 {
+		t.Fatalf("aside left behind: %v", entries)
+	}
+}
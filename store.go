@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// storeDir returns the central profile store: $XDG_DATA_HOME/switch, or the
+// platform data directory when XDG_DATA_HOME is not set.
+func storeDir() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "switch")
+	}
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+			return filepath.Join(dir, "switch")
+		}
+	}
+	home, _ := getHomeDir()
+	return filepath.Join(home, ".local", "share", "switch")
+}
+
+// centralPattern returns the switch pattern that keeps the profiles of an
+// app in the central store, in a folder named after the app through {app} so
+// renaming the app moves them along. Paths under the home directory are
+// written with ~ so the config stays portable.
+func centralPattern() string {
+	dir := filepath.ToSlash(storeDir())
+	if home, err := getHomeDir(); err == nil {
+		home = filepath.ToSlash(home)
+		if strings.HasPrefix(dir, home+"/") {
+			dir = "~" + strings.TrimPrefix(dir, home)
+		}
+	}
+	return dir + "/{app}/{name}"
+}
+
+// templatePattern returns the switch pattern for a new app created from tpl.
+// Templates without a pattern of their own use the central store.
+func templatePattern(tpl AppTemplate) string {
+	if tpl.Pattern != "" {
+		return tpl.Pattern
+	}
+	return centralPattern()
+}
+
+// movePath moves src to dst, falling back to a copy when they are on
+// different filesystems.
+func movePath(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := replacePath(src, dst); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// MigrateStore moves the snapshots of appName, or of every app when appName
+// is empty, into the central store and rewrites their switch patterns.
+func (s *Switcher) MigrateStore(appName string) error {
+	if err := s.checkSchemaVersion(); err != nil {
+		return err
+	}
+	names := s.appNames()
+	if appName != "" {
+		if _, exists := s.GetAppConfig(appName); !exists {
+			return fmt.Errorf("no configuration found for app '%s'", appName)
+		}
+		names = []string{appName}
+	}
+
+	migrated := 0
+	for _, name := range names {
+		moved, err := s.migrateApp(name)
+		if err != nil {
+			return fmt.Errorf("migrate %s: %w", name, err)
+		}
+		if moved {
+			migrated++
+		}
+	}
+	if migrated == 0 {
+		fmt.Printf("%s✓ All profiles are already in the central store%s\n", ColorGreen, ColorReset)
+	}
+	return nil
+}
+
+// migrateApp moves the snapshots of one app into the central store. It
+// reports whether the app was changed.
+func (s *Switcher) migrateApp(appName string) (bool, error) {
+	appConfig, err := s.resolveApp(appName)
+	if err != nil {
+		return false, err
+	}
+	pattern := centralPattern()
+	if appConfig.SwitchPattern == pattern {
+		return false, nil
+	}
+
+	authPath := expandPath(appConfig.AuthPath)
+	moves, err := snapshotMoves(appConfig, appName, pattern, appName)
+	if err != nil {
+		return false, err
+	}
+	if err := moveSnapshots(moves); err != nil {
+		return false, err
+	}
+
+	oldPattern := appConfig.SwitchPattern
+	appConfig.SwitchPattern = pattern
+	s.SetAppConfig(appName, appConfig)
+	if err := s.saveConfig(); err != nil {
+		appConfig.SwitchPattern = oldPattern
+		s.SetAppConfig(appName, appConfig)
+		undoMoves(moves)
+		return false, err
+	}
+
+	// Drop profile folders left empty, such as ~/.ssh/profiles.
+	for _, m := range moves {
+		if dir := filepath.Dir(m.from); dir != filepath.Dir(authPath) {
+			os.Remove(dir)
+		}
+	}
+	if len(moves) == 0 {
+		fmt.Printf("%s✓ Set the switch pattern of %s to %s%s\n", ColorGreen, appName, pattern, ColorReset)
+		return true, nil
+	}
+	fmt.Printf("%s✓ Moved %d %s profile(s) to %s%s\n", ColorGreen, len(moves), appName, filepath.Dir(resolveSwitchPattern(pattern, appName, authPath, "x")), ColorReset)
+	return true, nil
+}
+
+// snapshotMove is a snapshot that moves when an app's switch pattern or name
+// changes.
+type snapshotMove struct{ from, to string }
+
+// snapshotMoves lists the snapshots of appConfig, stored for appName, that
+// move when they are stored under pattern for newApp instead. It refuses to
+// overwrite an existing snapshot.
+func snapshotMoves(appConfig AppConfig, appName, pattern, newApp string) ([]snapshotMove, error) {
+	authPath := expandPath(appConfig.AuthPath)
+	var moves []snapshotMove
+	for _, acc := range appConfig.Accounts {
+		from := resolveSwitchPattern(appConfig.SwitchPattern, appName, authPath, acc)
+		to := resolveSwitchPattern(pattern, newApp, authPath, acc)
+		if !fileOrDirExists(from) || from == to {
+			continue
+		}
+		if fileOrDirExists(to) {
+			return nil, fmt.Errorf("snapshot already exists: %s", to)
+		}
+		moves = append(moves, snapshotMove{from, to})
+	}
+	return moves, nil
+}
+
+// moveSnapshots performs moves in order. If one fails, the snapshots already
+// moved are moved back.
+func moveSnapshots(moves []snapshotMove) error {
+	for i, m := range moves {
+		if err := movePath(m.from, m.to); err != nil {
+			undoMoves(moves[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// undoMoves moves snapshots back, last first.
+func undoMoves(moves []snapshotMove) {
+	for i := len(moves) - 1; i >= 0; i-- {
+		movePath(moves[i].to, moves[i].from)
+	}
+}
+
+// validateAppConfig checks an app's switch pattern, keys and globs before they are
+// used to take or restore a snapshot.
+func validateAppConfig(appConfig AppConfig) error {
+	if err := validateEnv(appConfig); err != nil {
+		return err
+	}
+	if err := validateSwitchPattern(appConfig); err != nil {
+		return err
+	}
+	if err := validateKeys(appConfig); err != nil {
+		return err
+	}
+	if err := validateIgnoreKeys(appConfig); err != nil {
+		return err
+	}
+	return validateGlobs(appConfig)
+}
+
+// validateProfileName rejects profile names that are not a single path
+// element. A name becomes part of the snapshot path, so an empty name, "."
+// or ".." would point at the store itself or a folder above it.
+func validateProfileName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("profile name must not be empty")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid profile name %q: it must not be . or .. or contain / or \\", name)
+	}
+	return nil
+}
+
+// validateSwitchPattern rejects switch patterns that would make a snapshot
+// contain itself: the config path itself, a folder around the config path,
+// or a location inside a config file. Every path of a multi-path app is
+// checked.
+func validateSwitchPattern(appConfig AppConfig) error {
+	if isMultiPath(appConfig) {
+		if err := validatePaths(appConfig); err != nil {
+			return err
+		}
+	}
+	// Placeholders are checked in the literal text and in variable defaults,
+	// split the same way expandEnv reads them.
+	for _, text := range envLiterals(appConfig.SwitchPattern) {
+		for {
+			start := strings.IndexByte(text, '{')
+			if start < 0 {
+				break
+			}
+			end := strings.IndexByte(text[start:], '}')
+			if end < 0 {
+				break
+			}
+			placeholder := text[start : start+end+1]
+			text = text[start+end+1:]
+			if !contains(patternPlaceholders, placeholder) {
+				return fmt.Errorf("invalid switch pattern %q: unknown placeholder %s", appConfig.SwitchPattern, placeholder)
+			}
+		}
+	}
+	// Check every profile, as names can change where the pattern resolves.
+	names := append(append([]string{}, appConfig.Accounts...), "profile")
+	for _, name := range names {
+		switchPath := resolveSwitchPattern(appConfig.SwitchPattern, appConfig.name, expandPath(appConfig.AuthPath), name)
+		for _, lp := range livePaths(appConfig) {
+			if err := checkSnapshotPath(appConfig.SwitchPattern, switchPath, lp.path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validatePaths checks the path names of a multi-path app, which become
+// entries of its snapshot folders.
+func validatePaths(appConfig AppConfig) error {
+	if appConfig.AuthPath != "" {
+		return fmt.Errorf("auth_path and paths cannot both be set")
+	}
+	for _, placeholder := range []string{"{auth_path}", "{auth_dir}", "{auth_base}"} {
+		if strings.Contains(appConfig.SwitchPattern, placeholder) {
+			return fmt.Errorf("invalid switch pattern %q: %s cannot be used with paths", appConfig.SwitchPattern, placeholder)
+		}
+	}
+	for name, p := range appConfig.Paths {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid path name %q", name)
+		}
+		if p == "" {
+			return fmt.Errorf("path %q is empty", name)
+		}
+	}
+	return nil
+}
+
+// validateEnv rejects config paths and switch patterns that use unset
+// environment variables, which would otherwise be taken literally.
+func validateEnv(appConfig AppConfig) error {
+	check := func(field, p string) error {
+		if _, ok := expandEnv(p); !ok {
+			return fmt.Errorf("invalid %s %q: environment variable is not set", field, p)
+		}
+		return nil
+	}
+	if err := check("auth_path", appConfig.AuthPath); err != nil {
+		return err
+	}
+	if err := check("switch_pattern", appConfig.SwitchPattern); err != nil {
+		return err
+	}
+	for _, lp := range livePaths(appConfig) {
+		if err := check("paths."+lp.name, appConfig.Paths[lp.name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkSnapshotPath(pattern, switchPath, authPath string) error {
+	if switchPath == authPath {
+		return fmt.Errorf("invalid switch pattern %q: it resolves to the config path itself", pattern)
+	}
+	if _, inside := relWithin(switchPath, authPath); inside {
+		return fmt.Errorf("invalid switch pattern %q: profiles would contain the config path %s", pattern, authPath)
+	}
+	if _, inside := relWithin(authPath, switchPath); inside && fileOrDirExists(authPath) && !isFolder(authPath) {
+		return fmt.Errorf("invalid switch pattern %q: profiles cannot be stored inside the file %s", pattern, authPath)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCentralPattern(t *testing.T) {
+	home := setHome(t)
+	if got := centralPattern(); got != "~/.local/share/switch/{app}/{name}" {
+		t.Fatalf("unexpected default pattern: %s", got)
+	}
+	data := filepath.Join(home, "data")
+	t.Setenv("XDG_DATA_HOME", data)
+	if got := centralPattern(); got != "~/data/switch/{app}/{name}" {
+		t.Fatalf("unexpected XDG pattern: %s", got)
+	}
+	elsewhere := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", elsewhere)
+	want := filepath.ToSlash(filepath.Join(elsewhere, "switch")) + "/{app}/{name}"
+	if got := centralPattern(); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	if got := templatePattern(AppTemplate{Pattern: "{auth_path}.{name}"}); got != "{auth_path}.{name}" {
+		t.Fatalf("template pattern not kept: %s", got)
+	}
+}
+
+func TestMigrateStore(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	ssh := filepath.Join(home, ".ssh")
+	for _, name := range []string{"work", "home"} {
+		p := filepath.Join(ssh, "profiles", name+".switch")
+		os.MkdirAll(p, 0700)
+		os.WriteFile(filepath.Join(p, "config"), []byte("Host "+name), 0600)
+	}
+	os.WriteFile(filepath.Join(ssh, "config"), []byte("Host work"), 0600)
+
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	s.SetAppConfig("ssh", AppConfig{Current: "work", Accounts: []string{"home", "work"}, AuthPath: "~/.ssh", SwitchPattern: "~/.ssh/profiles/{name}.switch"})
+
+	if code := run([]string{"migrate-store", "nosuch"}); code != 1 {
+		t.Fatalf("expected error for unknown app")
+	}
+	if err := s.MigrateStore(""); err != nil {
+		t.Fatal(err)
+	}
+	store := filepath.Join(home, ".local", "share", "switch")
+	if b, _ := os.ReadFile(filepath.Join(store, "codex", "b")); string(b) != `{"token":"b"}` {
+		t.Fatalf("codex snapshot not moved: %q", b)
+	}
+	if fileOrDirExists(authPath + ".b.switch") {
+		t.Fatalf("old codex snapshot left behind")
+	}
+	if b, _ := os.ReadFile(filepath.Join(store, "ssh", "home", "config")); string(b) != "Host home" {
+		t.Fatalf("ssh snapshot not moved: %q", b)
+	}
+	if fileOrDirExists(filepath.Join(ssh, "profiles")) {
+		t.Fatalf("empty profiles folder left inside ~/.ssh")
+	}
+	if !fileOrDirExists(authPath) {
+		t.Fatalf("live config removed")
+	}
+
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if app, _ := s.GetAppConfig("ssh"); app.SwitchPattern != "~/.local/share/switch/{app}/{name}" {
+		t.Fatalf("pattern not rewritten: %s", app.SwitchPattern)
+	}
+	if err := s.SwitchAccount("ssh", "home"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(ssh, "config")); string(b) != "Host home" {
+		t.Fatalf("switch after migration failed: %q", b)
+	}
+
+	// Running again is a no-op
+	out, _ := captureOutput(t, func() {
+		if err := s.MigrateStore("codex"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "already in the central store") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+
+	// A central pattern written with the app name is rewritten to {app}
+	app, _ := s.GetAppConfig("codex")
+	app.SwitchPattern = "~/.local/share/switch/codex/{name}"
+	s.SetAppConfig("codex", app)
+	if err := s.MigrateStore("codex"); err != nil {
+		t.Fatal(err)
+	}
+	if app, _ := s.GetAppConfig("codex"); app.SwitchPattern != "~/.local/share/switch/{app}/{name}" {
+		t.Fatalf("literal pattern not rewritten: %s", app.SwitchPattern)
+	}
+	if b, _ := os.ReadFile(filepath.Join(store, "codex", "b")); string(b) != `{"token":"b"}` {
+		t.Fatalf("snapshot lost: %q", b)
+	}
+}
+
+func TestMigrateStore_RefusesCollision(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`})
+	target := filepath.Join(home, ".local", "share", "switch", "codex", "a")
+	os.MkdirAll(filepath.Dir(target), 0755)
+	os.WriteFile(target, []byte("other"), 0600)
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	if err := s.MigrateStore("codex"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected collision error, got %v", err)
+	}
+	if !fileOrDirExists(authPath + ".a.switch") {
+		t.Fatalf("snapshot moved despite collision")
+	}
+	if app, _ := s.GetAppConfig("codex"); app.SwitchPattern != "{auth_path}.{name}.switch" {
+		t.Fatalf("pattern changed despite collision: %s", app.SwitchPattern)
+	}
+}
+
+func TestValidateSwitchPattern_Placeholders(t *testing.T) {
+	setHome(t)
+	t.Setenv("SWITCH_TEST_STORE", "/tmp/switch-store")
+	valid := []AppConfig{
+		{AuthPath: "~/.codex/auth.json", SwitchPattern: "${SWITCH_TEST_STORE}/{host}/{app}/{name}"},
+		{AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_dir}/../profiles/{auth_base}.{user}.{name}"},
+		{AuthPath: "$SWITCH_TEST_STORE/auth.json", SwitchPattern: "~/p/{name}"},
+		{AuthPath: "~/.codex/auth.json", SwitchPattern: "${SWITCH_TEST_UNSET:-~/p/{app}}/{name}"},
+		{AuthPath: "~/.codex/auth.json", SwitchPattern: "${SWITCH_TEST_UNSET:-${SWITCH_TEST_STORE}}/{name}"},
+	}
+	for _, c := range valid {
+		if err := validateAppConfig(c); err != nil {
+			t.Errorf("pattern %q: %v", c.SwitchPattern, err)
+		}
+	}
+	invalid := []AppConfig{
+		{AuthPath: "~/.codex/auth.json", SwitchPattern: "~/p/{profile}"},
+		{AuthPath: "~/.codex/auth.json", SwitchPattern: "${SWITCH_TEST_UNSET}/{name}"},
+		{AuthPath: "${SWITCH_TEST_UNSET}/auth.json", SwitchPattern: "~/p/{name}"},
+		{Paths: map[string]string{"a": "~/a"}, SwitchPattern: "{auth_dir}/{name}"},
+		{Paths: map[string]string{"a": "$SWITCH_TEST_UNSET/a"}, SwitchPattern: "~/p/{name}"},
+		{AuthPath: "~/.codex/auth.json", SwitchPattern: "${SWITCH_TEST_UNSET:-~/p/{bogus}}/{name}"},
+		{AuthPath: "~/.codex/auth.json", SwitchPattern: "${SWITCH_TEST_UNSET:-${SWITCH_TEST_UNSET}}/{name}"},
+		{AuthPath: "~/.codex/auth.json", SwitchPattern: "~/.codex/{name}", Accounts: []string{"auth.json"}},
+	}
+	for _, c := range invalid {
+		if err := validateAppConfig(c); err == nil {
+			t.Errorf("expected %+v to be rejected", c)
+		}
+	}
+}
+
+func TestRenameApp_MovesAppSnapshots(t *testing.T) {
+	home := setHome(t)
+	setupCodexFiles(t, home, `{"token":"a"}`, nil)
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Accounts: []string{}, AuthPath: "~/.codex/auth.json", SwitchPattern: "~/profiles/{app}/{name}"})
+	if err := s.AddAccount("codex", "a"); err != nil {
+		t.Fatal(err)
+	}
+	old := filepath.Join(home, "profiles", "codex", "a")
+	if !fileOrDirExists(old) {
+		t.Fatalf("snapshot not stored under the app name")
+	}
+	if err := s.RenameApp("codex", "openai"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(home, "profiles", "openai", "a")); string(b) != `{"token":"a"}` || fileOrDirExists(old) {
+		t.Fatalf("snapshot not moved with the app")
+	}
+	if cur := s.findCurrentAccount("openai"); cur != "a" {
+		t.Fatalf("expected current a after rename, got %q", cur)
+	}
+
+	os.MkdirAll(filepath.Join(home, "profiles", "codex"), 0755)
+	os.WriteFile(filepath.Join(home, "profiles", "codex", "a"), []byte("{}"), 0600)
+	if err := s.RenameApp("openai", "codex"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected collision error, got %v", err)
+	}
+	if _, ok := s.GetAppConfig("openai"); !ok {
+		t.Fatalf("app renamed despite collision")
+	}
+}
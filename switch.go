@@ -2,15 +2,20 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"os/user"
+	"path"
 	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -27,8 +32,13 @@ const (
 var version = "1.0.2"
 
 type Config struct {
-	Default DefaultConfig        `toml:"default"`
-	Apps    map[string]AppConfig `toml:"apps"`
+	// SchemaVersion is the layout of the config; see configSchemaVersion.
+	SchemaVersion int                          `toml:"schema_version"`
+	Default       DefaultConfig                `toml:"default"`
+	Apps          map[string]AppConfig         `toml:"apps"`
+	Contexts      map[string]map[string]string `toml:"contexts,omitempty"`
+	Templates     map[string]AppTemplate       `toml:"templates,omitempty"`
+	Encryption    *EncryptionConfig            `toml:"encryption,omitempty"`
 }
 
 type DefaultConfig struct {
@@ -40,59 +50,155 @@ type AppConfig struct {
 	Accounts      []string `toml:"accounts"`
 	AuthPath      string   `toml:"auth_path"`
 	SwitchPattern string   `toml:"switch_pattern"`
+	// Paths replaces AuthPath for apps whose profiles cover several files
+	// or folders. Each path is stored under its name in the snapshot folder.
+	Paths map[string]string `toml:"paths,omitempty"`
+	// Keys limits a file app to the values at these JSON pointers. The
+	// rest of the live file is left alone on switch. Format is json, toml,
+	// yaml or ini, and is guessed from the file name when empty.
+	Keys   []string `toml:"keys,omitempty"`
+	Format string   `toml:"format,omitempty"`
+	// IgnoreKeys lists volatile JSON keys, such as refresh timestamps, that
+	// are not compared when detecting the current profile. Entries starting
+	// with / are JSON pointers; others match a key name at any depth.
+	IgnoreKeys []string `toml:"ignore_keys,omitempty"`
+	Include    []string `toml:"include,omitempty"`
+	Exclude    []string `toml:"exclude,omitempty"`
+	PreSwitch  string   `toml:"pre_switch,omitempty"`
+	PostSwitch string   `toml:"post_switch,omitempty"`
+	Encrypt    bool     `toml:"encrypt,omitempty"`
+
+	// name is the app's name in the config, used for {app} in the switch
+	// pattern. It is set by GetAppConfig and not saved.
+	name string
 }
 
+// AppTemplate describes a known application. An empty Pattern keeps the
+// app's profiles in the central store. Include and Exclude are the default
+// globs for folder apps, and IgnoreKeys the volatile keys of JSON files.
+// Users can define templates under [templates] in the config or as files in
+// the templates directory; Source records where a template came from.
+//
+// AuthPaths lists candidate config paths per GOOS, or for "all" systems,
+// tried before AuthPath. Paths may use $VAR, ${VAR} and ${VAR:-default};
+// candidates whose variables are unset are skipped. DetectPaths are extra
+// paths whose existence means the app is installed.
 type AppTemplate struct {
-	DetectPaths []string
-	AuthPath    string
-	Pattern     string
-	Description string
+	DetectPaths []string            `toml:"detect_paths,omitempty"`
+	AuthPath    string              `toml:"auth_path"`
+	AuthPaths   map[string][]string `toml:"auth_paths,omitempty"`
+	Pattern     string              `toml:"switch_pattern,omitempty"`
+	Description string              `toml:"description,omitempty"`
+	Include     []string            `toml:"include,omitempty"`
+	Exclude     []string            `toml:"exclude,omitempty"`
+	IgnoreKeys  []string            `toml:"ignore_keys,omitempty"`
+	Source      string              `toml:"-"`
 }
 
 type Switcher struct {
 	configPath string
 	config     *Config
+	lock       *configLock
+	key        []byte
 }
 
 var stdinReader = bufio.NewReader(os.Stdin)
 
 var AppTemplates = map[string]AppTemplate{
 	"codex": {
-		DetectPaths: []string{"~/.codex/auth.json"},
 		AuthPath:    "~/.codex/auth.json",
-		Pattern:     "{auth_path}.{name}.switch",
+		AuthPaths:   map[string][]string{"all": {"${CODEX_HOME}/auth.json"}},
 		Description: "Codex authentication file",
+		IgnoreKeys:  []string{"last_refresh"},
 	},
 	"claude": {
-		DetectPaths: []string{"~/.claude/config.json"},
 		AuthPath:    "~/.claude/config.json",
-		Pattern:     "{auth_path}.{name}.switch",
+		AuthPaths:   map[string][]string{"all": {"${CLAUDE_CONFIG_DIR}/config.json"}},
 		Description: "Claude configuration file",
 	},
 	"vscode": {
-		DetectPaths: []string{"~/.vscode/User", "~/Library/Application Support/Code/User"},
-		AuthPath:    "~/.vscode/User",
-		Pattern:     "~/.vscode/profiles/{name}.switch",
+		AuthPath: "~/.vscode/User",
+		AuthPaths: map[string][]string{
+			"linux":   {"${XDG_CONFIG_HOME:-~/.config}/Code/User"},
+			"darwin":  {"~/Library/Application Support/Code/User"},
+			"windows": {"${APPDATA}/Code/User"},
+		},
 		Description: "VSCode user settings folder",
+		Include:     []string{"settings.json", "keybindings.json", "snippets/"},
 	},
 	"cursor": {
-		DetectPaths: []string{"~/.cursor", "~/Library/Application Support/Cursor"},
 		AuthPath:    "~/.cursor",
-		Pattern:     "~/.cursor/profiles/{name}.switch",
+		AuthPaths:   map[string][]string{"darwin": {"~/.cursor", "~/Library/Application Support/Cursor"}},
 		Description: "Cursor configuration folder",
+		Exclude:     []string{"extensions/", "projects/", "**/*.log"},
 	},
 	"ssh": {
-		DetectPaths: []string{"~/.ssh"},
 		AuthPath:    "~/.ssh",
-		Pattern:     "~/.ssh/profiles/{name}.switch",
 		Description: "SSH configuration folder",
 	},
 	"git": {
-		DetectPaths: []string{"~/.gitconfig"},
 		AuthPath:    "~/.gitconfig",
-		Pattern:     "{auth_path}.{name}.switch",
+		AuthPaths:   map[string][]string{"all": {"~/.gitconfig", "${XDG_CONFIG_HOME:-~/.config}/git/config"}},
 		Description: "Git configuration file",
 	},
+	"aws": {
+		DetectPaths: []string{"~/.aws/credentials", "~/.aws/config"},
+		AuthPath:    "~/.aws",
+		Description: "AWS CLI credentials and config folder",
+		Exclude:     []string{"cli/"},
+	},
+	"kube": {
+		AuthPath:    "~/.kube/config",
+		Description: "Kubernetes kubeconfig file",
+	},
+	"gcloud": {
+		AuthPath: "~/.config/gcloud",
+		AuthPaths: map[string][]string{
+			"all":     {"${CLOUDSDK_CONFIG}"},
+			"windows": {"${APPDATA}/gcloud"},
+		},
+		Description: "Google Cloud SDK config folder",
+		Exclude:     []string{"logs/", "virtenv/"},
+	},
+	"gh": {
+		AuthPath: "~/.config/gh/hosts.yml",
+		AuthPaths: map[string][]string{
+			"all":     {"${GH_CONFIG_DIR}/hosts.yml", "${XDG_CONFIG_HOME:-~/.config}/gh/hosts.yml"},
+			"windows": {"${APPDATA}/GitHub CLI/hosts.yml"},
+		},
+		Description: "GitHub CLI hosts file",
+	},
+	"npm": {
+		AuthPath:    "~/.npmrc",
+		AuthPaths:   map[string][]string{"all": {"${NPM_CONFIG_USERCONFIG}"}},
+		Description: "npm user config file",
+	},
+	"docker": {
+		AuthPath:    "~/.docker/config.json",
+		AuthPaths:   map[string][]string{"all": {"${DOCKER_CONFIG}/config.json"}},
+		Description: "Docker client config file",
+	},
+	"azure": {
+		AuthPath:    "~/.azure",
+		AuthPaths:   map[string][]string{"all": {"${AZURE_CONFIG_DIR}"}},
+		Description: "Azure CLI config folder",
+		Exclude:     []string{"logs/", "commands/", "telemetry/", "cliextensions/", "**/*.log"},
+	},
+	"terraform": {
+		AuthPath:    "~/.terraform.d/credentials.tfrc.json",
+		AuthPaths:   map[string][]string{"windows": {"${APPDATA}/terraform.d/credentials.tfrc.json"}},
+		Description: "Terraform CLI credentials file",
+	},
+	"pip": {
+		AuthPath: "~/.config/pip/pip.conf",
+		AuthPaths: map[string][]string{
+			"all":     {"${PIP_CONFIG_FILE}"},
+			"linux":   {"${XDG_CONFIG_HOME:-~/.config}/pip/pip.conf", "~/.pip/pip.conf"},
+			"darwin":  {"~/Library/Application Support/pip/pip.conf", "~/.config/pip/pip.conf", "~/.pip/pip.conf"},
+			"windows": {"${APPDATA}/pip/pip.ini"},
+		},
+		Description: "pip config file",
+	},
 }
 
 // getHomeDir returns the user's home directory, respecting environment variables.
@@ -119,20 +225,42 @@ func NewSwitcher() (*Switcher, error) {
 		return nil, fmt.Errorf("get home dir: %w", err)
 	}
 	configPath := filepath.Join(home, ".switch.toml")
-	s := &Switcher{configPath: configPath}
+	// Hold the lock for the lifetime of the switcher so the whole
+	// load-modify-save cycle is serialized across concurrent invocations.
+	lock, err := acquireConfigLock(configPath)
+	if err != nil {
+		return nil, err
+	}
+	s := &Switcher{configPath: configPath, lock: lock}
 	if err := s.loadConfig(); err != nil {
+		lock.release()
 		return nil, err
 	}
+	s.pruneKeyCache()
 	return s, nil
 }
 
+// stateDir holds data switch keeps next to its config, such as stashed
+// configs.
+func (s *Switcher) stateDir() string {
+	return filepath.Join(filepath.Dir(s.configPath), ".switch")
+}
+
+// Close releases the config lock taken by NewSwitcher.
+func (s *Switcher) Close() error {
+	err := s.lock.release()
+	s.lock = nil
+	return err
+}
+
 func (s *Switcher) loadConfig() error {
 	data, err := os.ReadFile(s.configPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			s.config = &Config{
-				Default: DefaultConfig{Config: "codex"},
-				Apps:    make(map[string]AppConfig),
+				SchemaVersion: configSchemaVersion,
+				Default:       DefaultConfig{Config: "codex"},
+				Apps:          make(map[string]AppConfig),
 			}
 			return s.saveConfig()
 		}
@@ -145,17 +273,62 @@ func (s *Switcher) loadConfig() error {
 	if s.config.Apps == nil {
 		s.config.Apps = make(map[string]AppConfig)
 	}
-	return nil
+	return s.migrateConfig(data)
 }
 
 func (s *Switcher) saveConfig() error {
-	file, err := os.Create(s.configPath)
+	if err := s.checkSchemaVersion(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(s.config); err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+	if err := writeFileAtomic(s.configPath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it
+// into place, so readers never observe a partially written file. An existing
+// file keeps its permissions; new files are created with perm. A symlink at
+// path is kept and the file it points to is replaced.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	path = resolveLink(path)
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		perm = info.Mode().Perm()
+	}
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, stagePrefix(path))
 	if err != nil {
-		return fmt.Errorf("create config: %w", err)
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
 	}
-	defer file.Close()
-	encoder := toml.NewEncoder(file)
-	return encoder.Encode(s.config)
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	syncDir(dir)
+	return nil
 }
 
 // Utility functions
@@ -170,7 +343,17 @@ func expandPath(p string) string {
 	// Normalize any backslashes to forward slashes first
 	// so we can treat separators uniformly across platforms.
 	p = strings.ReplaceAll(p, "\\", "/")
+	// Unset variables are left as written so the path is clearly wrong
+	// rather than silently pointing elsewhere.
+	p, _ = expandEnv(p)
+	return expandHome(p)
+}
 
+// expandHome expands a leading ~ in a slash-separated path and cleans it.
+func expandHome(p string) string {
+	if p == "" {
+		return p
+	}
 	if strings.HasPrefix(p, "~") {
 		home, _ := getHomeDir()
 		switch {
@@ -200,24 +383,441 @@ func isFolder(path string) bool {
 	return err == nil && stat.IsDir()
 }
 
-func resolveSwitchPattern(pattern, authPath, name string) string {
-	resolved := strings.ReplaceAll(pattern, "{auth_path}", authPath)
-	resolved = strings.ReplaceAll(resolved, "{name}", name)
+// expandEnv expands $VAR, ${VAR} and ${VAR:-default} in s. It reports
+// false if a variable without a default is unset; such references are left
+// as written.
+func expandEnv(s string) (string, bool) {
+	var b strings.Builder
+	ok := true
+	for _, part := range splitEnv(s) {
+		if part.name == "" {
+			b.WriteString(part.text)
+		} else if value := os.Getenv(part.name); value != "" {
+			b.WriteString(strings.ReplaceAll(value, "\\", "/"))
+		} else if part.hasDef {
+			def, defOK := expandEnv(part.def)
+			b.WriteString(def)
+			ok = ok && defOK
+		} else {
+			b.WriteString(part.text)
+			ok = false
+		}
+	}
+	return b.String(), ok
+}
+
+// envPart is a run of literal text, or a reference to an environment
+// variable when name is set. text is the part as written.
+type envPart struct {
+	text   string
+	name   string
+	def    string
+	hasDef bool
+}
+
+// splitEnv splits s into literal text and $VAR, ${VAR} and ${VAR:-default}
+// references. Braces nest, so ${A:-${B}} and ${A:-{name}} end at the
+// matching brace.
+func splitEnv(s string) []envPart {
+	var parts []envPart
+	lit := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			continue
+		}
+		var part envPart
+		if s[i+1] == '{' {
+			depth, end := 0, -1
+			for j := i + 1; j < len(s) && end < 0; j++ {
+				if s[j] == '{' {
+					depth++
+				} else if s[j] == '}' {
+					if depth--; depth == 0 {
+						end = j
+					}
+				}
+			}
+			if end < 0 {
+				continue
+			}
+			part.text = s[i : end+1]
+			part.name, part.def, part.hasDef = strings.Cut(part.text[2:len(part.text)-1], ":-")
+		} else {
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] >= 'A' && s[j] <= 'Z' || s[j] >= 'a' && s[j] <= 'z' || j > i+1 && s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+			part.text = s[i:j]
+			part.name = part.text[1:]
+		}
+		if part.name == "" {
+			continue
+		}
+		if i > lit {
+			parts = append(parts, envPart{text: s[lit:i]})
+		}
+		parts = append(parts, part)
+		i += len(part.text) - 1
+		lit = i + 1
+	}
+	if lit < len(s) {
+		parts = append(parts, envPart{text: s[lit:]})
+	}
+	return parts
+}
+
+// envLiterals returns the literal text of s outside variable names,
+// including the text of defaults, which may still end up in the result.
+func envLiterals(s string) []string {
+	var out []string
+	for _, part := range splitEnv(s) {
+		if part.name == "" {
+			out = append(out, part.text)
+		} else if part.hasDef {
+			out = append(out, envLiterals(part.def)...)
+		}
+	}
+	return out
+}
+
+// patternPlaceholders are the placeholders a switch pattern may use.
+var patternPlaceholders = []string{"{name}", "{app}", "{auth_path}", "{auth_dir}", "{auth_base}", "{host}", "{user}"}
+
+// resolveSwitchPattern returns the snapshot path of profile name. Variables
+// in pattern are expanded first, so values substituted for placeholders are
+// never expanded again.
+func resolveSwitchPattern(pattern, appName, authPath, name string) string {
 	// Support patterns that use backslashes as separators
+	resolved := strings.ReplaceAll(pattern, "\\", "/")
+	resolved, _ = expandEnv(resolved)
+	values := []string{"{name}", name, "{app}", appName, "{auth_path}", authPath}
+	if authPath != "" {
+		values = append(values, "{auth_dir}", path.Dir(authPath), "{auth_base}", path.Base(authPath))
+	}
+	if strings.Contains(resolved, "{host}") {
+		values = append(values, "{host}", patternHost())
+	}
+	if strings.Contains(resolved, "{user}") {
+		values = append(values, "{user}", patternUser())
+	}
+	resolved = strings.NewReplacer(values...).Replace(resolved)
 	resolved = strings.ReplaceAll(resolved, "\\", "/")
 	// Expand ~ and normalize to slash form
-	return expandPath(resolved)
+	return expandHome(resolved)
+}
+
+// patternHost returns the short host name used for {host}.
+func patternHost() string {
+	host, _ := os.Hostname()
+	host, _, _ = strings.Cut(host, ".")
+	return host
+}
+
+// patternUser returns the login name used for {user}, without the Windows
+// domain.
+func patternUser() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	} else if name == "" {
+		name = os.Getenv("USERNAME")
+	}
+	if i := strings.LastIndex(name, "\\"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
 }
 
 // File and folder operations
 func copyPath(src, dst string) error {
+	return copyPathWith(src, dst, copyOptions{})
+}
+
+// fileTransform rewrites the content of each file on its way to a copy, for
+// example to encrypt or decrypt a snapshot. A nil transform copies files
+// unchanged.
+type fileTransform func([]byte) ([]byte, error)
+
+// copyOptions tunes how a config is copied: transform rewrites file content
+// and filter selects the folder entries that are copied.
+type copyOptions struct {
+	transform fileTransform
+	filter    pathFilter
+}
+
+func copyPathWith(src, dst string, opts copyOptions) error {
+	if isFolder(src) {
+		return copyFolderWith(src, dst, opts)
+	}
+	return copyFileWith(src, dst, opts.transform)
+}
+
+// stagePrefix is the name prefix of the temporary sibling that a write to
+// dst is staged in before being renamed into place.
+func stagePrefix(dst string) string {
+	return "." + filepath.Base(dst) + ".switch-stage-"
+}
+
+// asidePrefix is the name prefix used for the previous content of a folder
+// while it is being swapped out.
+func asidePrefix(dst string) string {
+	return "." + filepath.Base(dst) + ".switch-old-"
+}
+
+// replacePath atomically replaces dst with a copy of src. The copy is staged
+// next to dst and renamed into place, so a crash or a full disk leaves dst
+// holding either its old or its new content, never a mix of both. For
+// folders, keep lists paths relative to dst that are carried over from the
+// old folder instead of being replaced, such as a profile store that lives
+// inside the folder being switched.
+func replacePath(src, dst string, keep ...string) error {
+	return replacePathWith(src, dst, copyOptions{}, keep...)
+}
+
+// replacePathWith is replacePath with src copied according to opts.
+func replacePathWith(src, dst string, opts copyOptions, keep ...string) error {
+	dst = resolveLink(dst)
+	stage, err := stageCopy(src, dst, opts)
+	if err != nil {
+		return err
+	}
+	if err := swapInto(stage, dst, keep); err != nil {
+		os.RemoveAll(stage)
+		return err
+	}
+	syncDir(filepath.Dir(dst))
+	return nil
+}
+
+// resolveLink returns the path that a symlink at path points to, or path
+// itself. Swapping the target instead of the link keeps a config managed by
+// a dotfile manager linked, as writing through the link would.
+func resolveLink(path string) string {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		return real
+	}
+	// A dangling link, such as one whose target was moved aside by an
+	// interrupted swap, still names where the content belongs.
+	target, err := os.Readlink(path)
+	if err != nil {
+		return path
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	return target
+}
+
+// stageCopy copies src into a new stage next to dst, ready for swapInto,
+// and returns the path of the stage.
+func stageCopy(src, dst string, opts copyOptions) (string, error) {
+	dstDir := filepath.Dir(dst)
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return "", err
+	}
 	if isFolder(src) {
-		return copyFolder(src, dst)
+		stage, err := os.MkdirTemp(dstDir, stagePrefix(dst))
+		if err != nil {
+			return "", err
+		}
+		if err := copyFolderWith(src, stage, opts); err != nil {
+			os.RemoveAll(stage)
+			return "", err
+		}
+		return stage, nil
+	}
+	file, err := os.CreateTemp(dstDir, stagePrefix(dst))
+	if err != nil {
+		return "", err
+	}
+	stage := file.Name()
+	file.Close()
+	if err := copyFileWith(src, stage, opts.transform); err != nil {
+		os.Remove(stage)
+		return "", err
 	}
-	return copyFile(src, dst)
+	return stage, nil
+}
+
+// swapInto renames a fully written stage over dst. Regular files are
+// replaced with a single rename. Folders cannot be renamed over an existing
+// folder, so the old one is first moved aside and only removed once the new
+// one is in place; recoverSwap undoes an interrupted swap.
+func swapInto(stage, dst string, keep []string) error {
+	if _, err := os.Lstat(dst); os.IsNotExist(err) {
+		return os.Rename(stage, dst)
+	}
+	if err := checkSameKind(stage, dst); err != nil {
+		return err
+	}
+	if !isFolder(stage) && !isFolder(dst) {
+		return os.Rename(stage, dst)
+	}
+	suffix := strings.TrimPrefix(filepath.Base(stage), stagePrefix(dst))
+	aside := filepath.Join(filepath.Dir(dst), asidePrefix(dst)+suffix)
+	if err := os.Rename(dst, aside); err != nil {
+		return err
+	}
+	if err := os.Rename(stage, dst); err != nil {
+		os.Rename(aside, dst)
+		return err
+	}
+	return finishSwap(aside, dst, keep)
+}
+
+// checkSameKind refuses to swap a file over a folder or a folder over a
+// file. Either is almost certainly a mistake, such as a snapshot path that
+// resolves to the store itself, and would delete what dst holds.
+func checkSameKind(stage, dst string) error {
+	if isFolder(stage) && !isFolder(dst) {
+		return fmt.Errorf("cannot replace the file %s with a folder", dst)
+	}
+	if !isFolder(stage) && isFolder(dst) {
+		return fmt.Errorf("cannot replace the folder %s with a file", dst)
+	}
+	return nil
+}
+
+// renamePath is os.Rename. It is a variable so tests can make a swap fail.
+var renamePath = os.Rename
+
+// swapAside moves dst aside and renames stage into its place, like swapInto,
+// but keeps the original so swapBack can restore it. An empty stage removes
+// dst. aside is empty when dst did not exist.
+func swapAside(stage, dst string) (aside string, err error) {
+	if _, err := os.Lstat(dst); os.IsNotExist(err) {
+		if stage == "" {
+			return "", nil
+		}
+		return "", renamePath(stage, dst)
+	}
+	if stage == "" {
+		// Reserve a unique aside name, as there is no stage to take it from.
+		dir, err := os.MkdirTemp(filepath.Dir(dst), asidePrefix(dst))
+		if err != nil {
+			return "", err
+		}
+		os.Remove(dir)
+		if err := renamePath(dst, dir); err != nil {
+			return "", err
+		}
+		return dir, nil
+	}
+	if err := checkSameKind(stage, dst); err != nil {
+		return "", err
+	}
+	suffix := strings.TrimPrefix(filepath.Base(stage), stagePrefix(dst))
+	aside = filepath.Join(filepath.Dir(dst), asidePrefix(dst)+suffix)
+	if err := renamePath(dst, aside); err != nil {
+		return "", err
+	}
+	if err := renamePath(stage, dst); err != nil {
+		os.Rename(aside, dst)
+		return "", err
+	}
+	return aside, nil
+}
+
+// swapBack undoes swapAside, putting the original dst back.
+func swapBack(aside, dst string) error {
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	if aside == "" {
+		return nil
+	}
+	return os.Rename(aside, dst)
+}
+
+// finishSwap carries the keep entries over from the swapped-out folder and
+// then removes it.
+func finishSwap(aside, dst string, keep []string) error {
+	for _, rel := range keep {
+		from := filepath.Join(aside, rel)
+		if _, err := os.Lstat(from); err != nil {
+			continue
+		}
+		to := filepath.Join(dst, rel)
+		if err := os.RemoveAll(to); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(aside)
+}
+
+// recoverSwap cleans up after a replacePath that was interrupted. If dst is
+// missing, the swapped-out copy is moved back; otherwise the swap completed
+// and only the entries that filter does not manage still need to be carried
+// over. Leftover stage files are always removed.
+func recoverSwap(dst string, filter pathFilter) error {
+	dst = resolveLink(dst)
+	dir := filepath.Dir(dst)
+	stages, _ := filepath.Glob(filepath.Join(dir, stagePrefix(dst)+"*"))
+	for _, stage := range stages {
+		os.RemoveAll(stage)
+	}
+	asides, _ := filepath.Glob(filepath.Join(dir, asidePrefix(dst)+"*"))
+	if len(asides) == 0 {
+		return nil
+	}
+	sort.Strings(asides)
+	if _, err := os.Lstat(dst); os.IsNotExist(err) {
+		latest := asides[len(asides)-1]
+		if err := os.Rename(latest, dst); err != nil {
+			return err
+		}
+		asides = asides[:len(asides)-1]
+	}
+	for _, aside := range asides {
+		if err := finishSwap(aside, dst, filter.unmanaged(aside)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDir flushes a directory entry so a completed rename survives a crash.
+// Not every platform supports syncing directories, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// nestedStorePaths returns the top-level entries of authPath that hold
+// profile snapshots, for apps whose switch pattern stores profiles inside
+// the folder being switched.
+func nestedStorePaths(appConfig AppConfig, authPath string) []string {
+	var keep []string
+	for _, acc := range appConfig.Accounts {
+		switchPath := resolveSwitchPattern(appConfig.SwitchPattern, appConfig.name, authPath, acc)
+		rel, ok := relWithin(authPath, switchPath)
+		if !ok {
+			continue
+		}
+		top := strings.Split(rel, "/")[0]
+		if !contains(keep, top) {
+			keep = append(keep, top)
+		}
+	}
+	sort.Strings(keep)
+	return keep
 }
 
 func copyFile(src, dst string) error {
+	return copyFileWith(src, dst, nil)
+}
+
+func copyFileWith(src, dst string, transform fileTransform) error {
 	srcInfo, err := os.Stat(src)
 	if err != nil {
 		return err
@@ -240,23 +840,112 @@ func copyFile(src, dst string) error {
 	}
 	defer destination.Close()
 
-	_, err = io.Copy(destination, source)
+	if transform == nil {
+		_, err = io.Copy(destination, source)
+	} else {
+		var data []byte
+		if data, err = io.ReadAll(source); err == nil {
+			if data, err = transform(data); err == nil {
+				_, err = destination.Write(data)
+			}
+		}
+	}
 	if err != nil {
 		return err
 	}
+	if err := destination.Sync(); err != nil {
+		return err
+	}
 	return os.Chmod(dst, perm)
 }
 
+// copyFolder makes dst an exact mirror of src: files are copied over and
+// anything in dst that does not exist in src is removed afterwards.
 func copyFolder(src, dst string) error {
+	return copyFolderWith(src, dst, copyOptions{})
+}
+
+// copyFolderWith mirrors the entries of src that opts.filter manages into
+// dst. Entries of dst that the filter does not manage are left alone.
+func copyFolderWith(src, dst string, opts copyOptions) error {
+	if err := copyTreeWith(src, dst, opts); err != nil {
+		return err
+	}
+	extra, err := extraneousPaths(src, dst, opts.filter)
+	if err != nil {
+		return err
+	}
+	for _, rel := range extra {
+		if err := os.RemoveAll(filepath.Join(dst, filepath.FromSlash(rel))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extraneousPaths lists the paths in dst (slash-separated, relative to dst)
+// that filter manages and that have no counterpart in src. A directory is
+// listed once rather than together with its contents.
+func extraneousPaths(src, dst string, filter pathFilter) ([]string, error) {
+	if !isFolder(dst) {
+		return nil, nil
+	}
+	var extra []string
+	err := filepath.Walk(dst, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		slashRel := filepath.ToSlash(rel)
+		if filter.skip(slashRel, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if _, err := os.Lstat(filepath.Join(src, rel)); os.IsNotExist(err) {
+			// A folder that is only partly managed is searched for the
+			// managed entries inside it instead.
+			if !info.IsDir() || filter.managed(slashRel) {
+				extra = append(extra, slashRel)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+			}
+		}
+		return nil
+	})
+	sort.Strings(extra)
+	return extra, err
+}
+
+func copyTreeWith(src, dst string, opts copyOptions) error {
+	cleanDst := filepath.Clean(dst)
 	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		// Never copy the destination into itself when it lives inside src.
+		if info.IsDir() && filepath.Clean(path) == cleanDst {
+			return filepath.SkipDir
+		}
 
 		relPath, err := filepath.Rel(src, path)
 		if err != nil {
 			return err
 		}
+		if relPath != "." && opts.filter.skip(filepath.ToSlash(relPath), info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		dstPath := filepath.Join(dst, relPath)
 
 		if info.IsDir() {
@@ -265,20 +954,34 @@ func copyFolder(src, dst string) error {
 			}
 			return os.Chmod(dstPath, info.Mode().Perm())
 		}
-		return copyFile(path, dstPath)
+		return copyFileWith(path, dstPath, opts.transform)
 	})
 }
 
 func contentEqual(a, b string) bool {
+	return contentEqualWith(a, b, pathFilter{}, nil)
+}
+
+// contentEqualWith is contentEqual with folders compared only on the entries
+// that filter manages. open, if set, is applied to the content of files
+// below b before comparing, for example to decrypt a snapshot.
+func contentEqualWith(a, b string, filter pathFilter, open fileTransform) bool {
 	if isFolder(a) && isFolder(b) {
-		return folderEqual(a, b)
+		return folderEqualWith(a, b, filter, open)
 	} else if !isFolder(a) && !isFolder(b) {
-		return fileEqual(a, b)
+		return fileEqualWith(a, b, filter, open)
 	}
 	return false
 }
 
 func fileEqual(a, b string) bool {
+	return fileEqualWith(a, b, pathFilter{}, nil)
+}
+
+// fileEqualWith is fileEqual with the keys, format and ignored keys of
+// filter applied, and open applied to b. JSON files are compared
+// semantically.
+func fileEqualWith(a, b string, filter pathFilter, open fileTransform) bool {
 	aData, err := os.ReadFile(a)
 	if err != nil {
 		return false
@@ -287,47 +990,198 @@ func fileEqual(a, b string) bool {
 	if err != nil {
 		return false
 	}
+	if open != nil {
+		if bData, err = open(bData); err != nil {
+			return false
+		}
+	}
+	if len(filter.keys) > 0 {
+		return keysEqual(aData, bData, filter.keys, filter.format, filter.ignore)
+	}
 
-	var aJSON, bJSON map[string]interface{}
-	if json.Unmarshal(aData, &aJSON) == nil && json.Unmarshal(bData, &bJSON) == nil {
-		return jsonEqual(aJSON, bJSON)
+	aJSON, aErr := decodeJSON(aData)
+	bJSON, bErr := decodeJSON(bData)
+	if aErr == nil && bErr == nil {
+		return valuesEqual(stripIgnored(aJSON, filter.ignore), stripIgnored(bJSON, filter.ignore))
 	}
 
 	return string(aData) == string(bData)
 }
 
 func folderEqual(a, b string) bool {
+	return folderEqualWith(a, b, pathFilter{}, nil)
+}
+
+func folderEqualWith(a, b string, filter pathFilter, open fileTransform) bool {
 	aInfo, aErr := os.Stat(a)
 	bInfo, bErr := os.Stat(b)
 	if aErr != nil || bErr != nil {
 		return false
 	}
-	return aInfo.IsDir() && bInfo.IsDir()
+	if !aInfo.IsDir() || !bInfo.IsDir() {
+		return false
+	}
+	aManifest, err := folderManifest(a, filter, nil)
+	if err != nil {
+		return false
+	}
+	bManifest, err := folderManifest(b, filter, open)
+	if err != nil {
+		return false
+	}
+	if len(aManifest) != len(bManifest) {
+		return false
+	}
+	for rel, entry := range aManifest {
+		if other, ok := bManifest[rel]; !ok || other != entry {
+			return false
+		}
+	}
+	return true
 }
 
-func jsonEqual(a, b map[string]interface{}) bool {
-	aBytes, _ := json.Marshal(a)
-	bBytes, _ := json.Marshal(b)
-	return string(aBytes) == string(bBytes)
+// manifestEntry describes one path below a folder root: its permission bits
+// and, for files, a hash of its content.
+type manifestEntry struct {
+	mode os.FileMode
+	hash string
+}
+
+// folderManifest maps every path below root (slash-separated, relative to
+// root) that filter manages to its manifest entry. Symlinks are followed,
+// matching copyFile. File content is hashed after open, if set.
+func folderManifest(root string, filter pathFilter, open fileTransform) (map[string]manifestEntry, error) {
+	manifest := make(map[string]manifestEntry)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if filter.skip(rel, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if filter.managed(rel) {
+				manifest[rel] = manifestEntry{mode: os.ModeDir | info.Mode().Perm()}
+			}
+			return nil
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		sum, err := fileHash(path, stat, open)
+		if err != nil {
+			return err
+		}
+		manifest[rel] = manifestEntry{mode: stat.Mode().Perm(), hash: sum}
+		return nil
+	})
+	return manifest, err
+}
+
+type cachedHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// hashCache remembers file hashes by path, size and modification time, so
+// comparing the live folder against every profile reads it only once.
+var hashCache = make(map[string]cachedHash)
+
+func fileHash(path string, info os.FileInfo, open fileTransform) (string, error) {
+	if open != nil {
+		// Transformed content, such as a decrypted snapshot, is hashed in
+		// memory and not cached.
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		if data, err = open(data); err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	if c, ok := hashCache[path]; ok && c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+		return c.sum, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	hashCache[path] = cachedHash{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	return sum, nil
 }
 
 // Application-agnostic functions
 func (s *Switcher) GetAppConfig(appName string) (AppConfig, bool) {
 	config, exists := s.config.Apps[appName]
+	if exists {
+		config.name = appName
+	}
 	return config, exists
 }
 
+// resolveApp returns the configuration of appName after checking that the
+// environment variables its paths use are set, so an unset variable is never
+// read as a path relative to the working directory.
+func (s *Switcher) resolveApp(appName string) (AppConfig, error) {
+	appConfig, exists := s.GetAppConfig(appName)
+	if !exists {
+		return AppConfig{}, fmt.Errorf("no configuration found for app '%s'", appName)
+	}
+	if err := validateEnv(appConfig); err != nil {
+		return AppConfig{}, fmt.Errorf("%s: %w", appName, err)
+	}
+	return appConfig, nil
+}
+
 func (s *Switcher) SetAppConfig(appName string, config AppConfig) {
 	s.config.Apps[appName] = config
 }
 
+// appNames returns the configured application names in sorted order.
+func (s *Switcher) appNames() []string {
+	names := make([]string, 0, len(s.config.Apps))
+	for name := range s.config.Apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (s *Switcher) AddAccount(appName, accountName string) error {
+	if err := validateProfileName(accountName); err != nil {
+		return err
+	}
+	if err := s.checkSchemaVersion(); err != nil {
+		return err
+	}
 	appConfig, exists := s.GetAppConfig(appName)
 	if !exists {
-		template, hasTemplate := AppTemplates[appName]
+		template, hasTemplate := s.templates()[appName]
 		if !hasTemplate {
 			return fmt.Errorf("no configuration found for app '%s'", appName)
 		}
+		template, _ = resolveTemplate(template)
 
 		authPath := expandPath(template.AuthPath)
 		if _, err := os.Stat(authPath); err != nil {
@@ -338,12 +1192,21 @@ func (s *Switcher) AddAccount(appName, accountName string) error {
 			Current:       "",
 			Accounts:      []string{},
 			AuthPath:      template.AuthPath,
-			SwitchPattern: template.Pattern,
+			SwitchPattern: templatePattern(template),
+			Include:       template.Include,
+			Exclude:       template.Exclude,
+			IgnoreKeys:    template.IgnoreKeys,
+			name:          appName,
 		}
 	}
 
+	check := appConfig
+	check.Accounts = append(append([]string{}, appConfig.Accounts...), accountName)
+	if err := validateAppConfig(check); err != nil {
+		return err
+	}
 	authPath := expandPath(appConfig.AuthPath)
-	switchPath := resolveSwitchPattern(appConfig.SwitchPattern, authPath, accountName)
+	switchPath := resolveSwitchPattern(appConfig.SwitchPattern, appName, authPath, accountName)
 
 	for _, acc := range appConfig.Accounts {
 		if acc == accountName {
@@ -359,7 +1222,9 @@ func (s *Switcher) AddAccount(appName, accountName string) error {
 		}
 	}
 
-	if err := copyPath(authPath, switchPath); err != nil {
+	// check lists the new profile, so a store nested in the live folder is
+	// left out of the first snapshot too.
+	if err := s.writeSnapshot(check, switchPath); err != nil {
 		return fmt.Errorf("copy config: %w", err)
 	}
 
@@ -373,62 +1238,391 @@ func (s *Switcher) AddAccount(appName, accountName string) error {
 
 	s.SetAppConfig(appName, appConfig)
 	if err := s.saveConfig(); err != nil {
-		os.RemoveAll(switchPath)
+		os.RemoveAll(switchPath)
+		return err
+	}
+
+	fmt.Printf("%s✓ Added account: %s for %s%s\n", ColorGreen, accountName, appName, ColorReset)
+	return nil
+}
+
+func (s *Switcher) SwitchAccount(appName, accountName string) error {
+	appConfig, err := s.resolveApp(appName)
+	if err != nil {
+		return err
+	}
+
+	if accountName == "" {
+		return s.CycleAccounts(appName)
+	}
+
+	if !contains(appConfig.Accounts, accountName) {
+		return fmt.Errorf("account '%s' not found for %s", accountName, appName)
+	}
+
+	authPath := expandPath(appConfig.AuthPath)
+	switchPath := resolveSwitchPattern(appConfig.SwitchPattern, appName, authPath, accountName)
+
+	if _, err := os.Stat(switchPath); err != nil {
+		return fmt.Errorf("switch file not found: %s", switchPath)
+	}
+
+	if err := validateAppConfig(appConfig); err != nil {
+		return err
+	}
+	// A config that cannot be saved must be refused before the live config
+	// is touched.
+	if err := s.checkSchemaVersion(); err != nil {
+		return err
+	}
+	if err := recoverLive(appConfig); err != nil {
+		return fmt.Errorf("recover interrupted switch: %w", err)
+	}
+	if appConfig.Encrypt || pathSealed(switchPath) {
+		if _, err := s.encryptionKey(); err != nil {
+			return err
+		}
+	}
+
+	currentAccount := s.findCurrentAccount(appName)
+	previous := currentAccount
+	if drifted, origin := s.checkDrift(appName, currentAccount); drifted {
+		if err := s.resolveDrift(appName, origin); err != nil {
+			return err
+		}
+		// Saving the changes may have added a profile.
+		appConfig, _ = s.GetAppConfig(appName)
+		previous = origin
+	}
+	livePathList := liveLabel(appConfig, string(os.PathListSeparator))
+	if err := runHook("pre_switch", appConfig.PreSwitch, appName, livePathList, previous, accountName); err != nil {
+		return fmt.Errorf("switch aborted: %w", err)
+	}
+	if currentAccount != "" && currentAccount != accountName {
+		currentSwitchPath := resolveSwitchPattern(appConfig.SwitchPattern, appName, authPath, currentAccount)
+		if err := s.writeSnapshot(appConfig, currentSwitchPath); err != nil {
+			return fmt.Errorf("backup current config: %w", err)
+		}
+	}
+
+	undo, err := s.captureUndo(appConfig)
+	if err != nil {
+		return fmt.Errorf("record undo: %w", err)
+	}
+	if err := s.restoreSnapshot(appConfig, switchPath); err != nil {
+		discardUndo(undo)
+		return fmt.Errorf("switch config: %w", err)
+	}
+	// Undo and context rollback rely on every switch being recorded, so a
+	// switch that cannot be recorded is reverted.
+	entry := HistoryEntry{Time: time.Now().UTC(), App: appName, From: previous, To: accountName, Undo: undo}
+	if err := s.recordSwitch(entry); err != nil {
+		if revertErr := s.revertSwitch(appConfig, undo); revertErr != nil {
+			return fmt.Errorf("record switch: %w (revert failed: %v)", err, revertErr)
+		}
+		return fmt.Errorf("record switch: %w", err)
+	}
+
+	previousConfig := appConfig
+	appConfig.Current = accountName
+	s.SetAppConfig(appName, appConfig)
+	if err := s.saveConfig(); err != nil {
+		s.SetAppConfig(appName, previousConfig)
+		s.forgetSwitch(entry)
+		if revertErr := s.revertSwitch(appConfig, undo); revertErr != nil {
+			return fmt.Errorf("save config: %w (revert failed: %v)", err, revertErr)
+		}
+		return fmt.Errorf("save config: %w", err)
+	}
+
+	if previous != "" && previous != accountName {
+		fmt.Printf("%s✓ %s account switched from %s to %s!%s\n",
+			ColorGreen, strings.Title(appName), previous, accountName, ColorReset)
+	} else {
+		fmt.Printf("%s✓ Switched to: %s%s\n", ColorGreen, accountName, ColorReset)
+	}
+
+	if err := runHook("post_switch", appConfig.PostSwitch, appName, livePathList, previous, accountName); err != nil {
+		fmt.Fprintf(os.Stderr, "%s! %v%s\n", ColorYellow, err, ColorReset)
+	}
+	return nil
+}
+
+// RemoveAccount deletes a profile and its stored snapshot. Unless assumeYes
+// is set the user is asked to confirm first. Removing the current profile
+// leaves the live config untouched and clears the current marker.
+func (s *Switcher) RemoveAccount(appName, accountName string, assumeYes bool) error {
+	appConfig, err := s.resolveApp(appName)
+	if err != nil {
+		return err
+	}
+	if !contains(appConfig.Accounts, accountName) {
+		return fmt.Errorf("account '%s' not found for %s", accountName, appName)
+	}
+
+	authPath := expandPath(appConfig.AuthPath)
+	switchPath := resolveSwitchPattern(appConfig.SwitchPattern, appName, authPath, accountName)
+
+	if !assumeYes {
+		ok, err := promptYesNo(fmt.Sprintf("Remove profile '%s' from %s and delete %s?", accountName, appName, switchPath), false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Printf("%sCancelled%s\n", ColorYellow, ColorReset)
+			return fmt.Errorf("cancelled by user")
+		}
+	}
+
+	var accounts []string
+	for _, acc := range appConfig.Accounts {
+		if acc != accountName {
+			accounts = append(accounts, acc)
+		}
+	}
+	appConfig.Accounts = accounts
+	wasCurrent := appConfig.Current == accountName
+	if wasCurrent {
+		appConfig.Current = ""
+	}
+	s.SetAppConfig(appName, appConfig)
+	contexts := s.removeProfileFromContexts(appName, accountName)
+	if err := s.saveConfig(); err != nil {
+		return err
+	}
+	// Switching back to the removed profile is no longer possible, so
+	// history entries that came from it now count as unsaved configs. Undo
+	// copies around switches to or from it may hold its credentials, so they
+	// are deleted with it.
+	discarded := 0
+	histErr := s.rewriteHistory(func(e *HistoryEntry) {
+		if e.App != appName {
+			return
+		}
+		if (e.From == accountName || e.To == accountName) && e.Undo != "" {
+			discardUndo(e.Undo)
+			e.Undo = ""
+			discarded++
+		}
+		if e.From == accountName {
+			e.From = ""
+		}
+	})
+
+	// The config no longer references the snapshot, so a failure here only
+	// leaves an orphaned file behind.
+	if err := os.RemoveAll(switchPath); err != nil {
+		return fmt.Errorf("delete snapshot: %w", err)
+	}
+
+	fmt.Printf("%s✓ Removed profile: %s from %s%s\n", ColorGreen, accountName, appName, ColorReset)
+	for _, name := range contexts {
+		fmt.Printf("%s! Removed %s from context %s%s\n", ColorYellow, appName, name, ColorReset)
+	}
+	if wasCurrent {
+		fmt.Printf("%s%s was the current profile; the live config at %s was left as is%s\n",
+			ColorYellow, accountName, liveLabel(appConfig, ", "), ColorReset)
+	}
+	if discarded > 0 {
+		fmt.Printf("%s! Deleted %d undo copies of switches to or from %s; those switches can no longer be undone%s\n",
+			ColorYellow, discarded, accountName, ColorReset)
+	}
+	if stash := filepath.Join(s.stateDir(), "stash", appName); fileOrDirExists(stash) {
+		fmt.Printf("%s! Stashed %s configs in %s may still hold %s; delete them if they are no longer needed%s\n",
+			ColorYellow, appName, stash, accountName, ColorReset)
+	}
+	if histErr != nil {
+		return fmt.Errorf("update history: %w", histErr)
+	}
+	return nil
+}
+
+// RenameAccount renames a profile, moving its stored snapshot and updating
+// the account list and current marker. It refuses to overwrite an existing
+// profile or snapshot.
+func (s *Switcher) RenameAccount(appName, oldName, newName string) error {
+	appConfig, err := s.resolveApp(appName)
+	if err != nil {
+		return err
+	}
+	if !contains(appConfig.Accounts, oldName) {
+		return fmt.Errorf("account '%s' not found for %s", oldName, appName)
+	}
+	if err := validateProfileName(newName); err != nil {
+		return err
+	}
+	if err := s.checkSchemaVersion(); err != nil {
+		return err
+	}
+	if contains(appConfig.Accounts, newName) {
+		return fmt.Errorf("account '%s' already exists for %s", newName, appName)
+	}
+
+	authPath := expandPath(appConfig.AuthPath)
+	oldPath := resolveSwitchPattern(appConfig.SwitchPattern, appName, authPath, oldName)
+	newPath := resolveSwitchPattern(appConfig.SwitchPattern, appName, authPath, newName)
+	if fileOrDirExists(newPath) {
+		return fmt.Errorf("snapshot already exists: %s", newPath)
+	}
+	// A profile whose snapshot went missing can still be renamed.
+	var moves []snapshotMove
+	if fileOrDirExists(oldPath) {
+		moves = append(moves, snapshotMove{oldPath, newPath})
+	}
+	if err := moveSnapshots(moves); err != nil {
+		return fmt.Errorf("move snapshot: %w", err)
+	}
+
+	previous := appConfig
+	renamed := append([]string{}, appConfig.Accounts...)
+	for i, acc := range renamed {
+		if acc == oldName {
+			renamed[i] = newName
+		}
+	}
+	sort.Strings(renamed)
+	appConfig.Accounts = renamed
+	if appConfig.Current == oldName {
+		appConfig.Current = newName
+	}
+	s.SetAppConfig(appName, appConfig)
+	s.renameProfileInContexts(appName, oldName, newName)
+	rename := func(from, to string) error {
+		return s.rewriteHistory(func(e *HistoryEntry) {
+			if e.App != appName {
+				return
+			}
+			if e.From == from {
+				e.From = to
+			}
+			if e.To == from {
+				e.To = to
+			}
+		})
+	}
+	err = rename(oldName, newName)
+	if err == nil {
+		if err = s.saveConfig(); err != nil {
+			rename(newName, oldName)
+		}
+	}
+	if err != nil {
+		s.SetAppConfig(appName, previous)
+		s.renameProfileInContexts(appName, newName, oldName)
+		undoMoves(moves)
+		return err
+	}
+
+	fmt.Printf("%s✓ Renamed %s profile %s to %s%s\n", ColorGreen, appName, oldName, newName, ColorReset)
+	return nil
+}
+
+// RenameApp renames a configured application, keeping it as the default app
+// if it was one. Snapshots stored under a pattern that uses {app} are moved
+// along.
+func (s *Switcher) RenameApp(oldName, newName string) error {
+	appConfig, exists := s.GetAppConfig(oldName)
+	if !exists {
+		return fmt.Errorf("app '%s' not found", oldName)
+	}
+	if newName == "" {
+		return fmt.Errorf("new app name must not be empty")
+	}
+	if _, taken := s.GetAppConfig(newName); taken {
+		return fmt.Errorf("app '%s' already exists", newName)
+	}
+	if err := validateEnv(appConfig); err != nil {
+		return fmt.Errorf("%s: %w", oldName, err)
+	}
+	if err := s.checkSchemaVersion(); err != nil {
+		return err
+	}
+	moves, err := snapshotMoves(appConfig, oldName, appConfig.SwitchPattern, newName)
+	if err != nil {
+		return err
+	}
+	if err := moveSnapshots(moves); err != nil {
+		return fmt.Errorf("move snapshots: %w", err)
+	}
+
+	wasDefault := s.config.Default.Config == oldName
+	revert := func() {
+		delete(s.config.Apps, newName)
+		s.SetAppConfig(oldName, appConfig)
+		if wasDefault {
+			s.config.Default.Config = oldName
+		}
+		s.renameAppInContexts(newName, oldName)
+		undoMoves(moves)
+	}
+	delete(s.config.Apps, oldName)
+	s.SetAppConfig(newName, appConfig)
+	if wasDefault {
+		s.config.Default.Config = newName
+	}
+	s.renameAppInContexts(oldName, newName)
+	if err := s.renameAppState(oldName, newName); err != nil {
+		revert()
+		return err
+	}
+	if err := s.saveConfig(); err != nil {
+		s.renameAppState(newName, oldName)
+		revert()
 		return err
 	}
 
-	fmt.Printf("%s✓ Added account: %s for %s%s\n", ColorGreen, accountName, appName, ColorReset)
+	fmt.Printf("%s✓ Renamed app %s to %s%s\n", ColorGreen, oldName, newName, ColorReset)
 	return nil
 }
 
-func (s *Switcher) SwitchAccount(appName, accountName string) error {
-	appConfig, exists := s.GetAppConfig(appName)
-	if !exists {
-		return fmt.Errorf("no configuration found for app '%s'", appName)
-	}
-
-	if accountName == "" {
-		return s.CycleAccounts(appName)
+// SaveCurrent writes the live config back into the snapshot of the app's
+// current profile, e.g. after a token refresh. It reports whether the
+// snapshot actually changed.
+func (s *Switcher) SaveCurrent(appName string) (bool, error) {
+	appConfig, err := s.resolveApp(appName)
+	if err != nil {
+		return false, err
 	}
-
-	if !contains(appConfig.Accounts, accountName) {
-		return fmt.Errorf("account '%s' not found for %s", accountName, appName)
+	accountName := appConfig.Current
+	if accountName == "" || !contains(appConfig.Accounts, accountName) {
+		return false, fmt.Errorf("no current profile for %s; run 'switch add %s <account>' to save it as a new profile", appName, appName)
 	}
 
 	authPath := expandPath(appConfig.AuthPath)
-	switchPath := resolveSwitchPattern(appConfig.SwitchPattern, authPath, accountName)
-
-	if _, err := os.Stat(switchPath); err != nil {
-		return fmt.Errorf("switch file not found: %s", switchPath)
+	if !liveExists(appConfig) {
+		return false, fmt.Errorf("auth path not found: %s", liveLabel(appConfig, ", "))
 	}
-
-	currentAccount := s.findCurrentAccount(appName)
-	if currentAccount != "" && currentAccount != accountName {
-		currentSwitchPath := resolveSwitchPattern(appConfig.SwitchPattern, authPath, currentAccount)
-		copyPath(authPath, currentSwitchPath)
+	switchPath := resolveSwitchPattern(appConfig.SwitchPattern, appName, authPath, accountName)
+	if appConfig.Encrypt || pathSealed(switchPath) {
+		if _, err := s.encryptionKey(); err != nil {
+			return false, err
+		}
 	}
-
-	if err := copyPath(switchPath, authPath); err != nil {
-		return fmt.Errorf("switch config: %w", err)
+	// The live config may match another profile, for example after logging
+	// in again and adding it, so only a config that matches none of them is
+	// saved into the current one.
+	matched, locked := s.detectCurrent(appName)
+	if locked {
+		if _, err := s.encryptionKey(); err != nil {
+			return false, err
+		}
+		matched, _ = s.detectCurrent(appName)
 	}
-
-	appConfig.Current = accountName
-	s.SetAppConfig(appName, appConfig)
-	s.saveConfig()
-
-	if currentAccount != "" && currentAccount != accountName {
-		fmt.Printf("%s✓ %s account switched from %s to %s!%s\n",
-			ColorGreen, strings.Title(appName), currentAccount, accountName, ColorReset)
-	} else {
-		fmt.Printf("%s✓ Switched to: %s%s\n", ColorGreen, accountName, ColorReset)
+	if matched != "" {
+		fmt.Printf("%s✓ Profile %s for %s is already up to date%s\n", ColorGreen, matched, appName, ColorReset)
+		return false, nil
 	}
-	return nil
+	if err := s.writeSnapshot(appConfig, switchPath); err != nil {
+		return false, fmt.Errorf("save config: %w", err)
+	}
+	fmt.Printf("%s✓ Saved live config to profile %s for %s%s\n", ColorGreen, accountName, appName, ColorReset)
+	return true, nil
 }
 
 func (s *Switcher) CycleAccounts(appName string) error {
-	appConfig, exists := s.GetAppConfig(appName)
-	if !exists {
-		return fmt.Errorf("no configuration found for app '%s'", appName)
+	appConfig, err := s.resolveApp(appName)
+	if err != nil {
+		return err
 	}
 
 	if len(appConfig.Accounts) == 0 {
@@ -437,46 +1631,137 @@ func (s *Switcher) CycleAccounts(appName string) error {
 		return fmt.Errorf("no accounts")
 	}
 
+	return s.SwitchAccount(appName, s.nextAccount(appName))
+}
+
+// nextAccount returns the account that CycleAccounts switches to: the one
+// after the current account, or the first if the current one is unknown.
+func (s *Switcher) nextAccount(appName string) string {
+	appConfig, _ := s.GetAppConfig(appName)
+	if len(appConfig.Accounts) == 0 {
+		return ""
+	}
 	current := s.findCurrentAccount(appName)
-	var next string
+	for i, acc := range appConfig.Accounts {
+		if acc == current {
+			return appConfig.Accounts[(i+1)%len(appConfig.Accounts)]
+		}
+	}
+	return appConfig.Accounts[0]
+}
 
-	if current == "" {
-		next = appConfig.Accounts[0]
-	} else {
-		for i, acc := range appConfig.Accounts {
-			if acc == current {
-				next = appConfig.Accounts[(i+1)%len(appConfig.Accounts)]
-				break
+// PreviewSwitch prints what switching appName to accountName would do,
+// including the files that would be removed from a folder config, without
+// changing anything.
+func (s *Switcher) PreviewSwitch(appName, accountName string) error {
+	appConfig, err := s.resolveApp(appName)
+	if err != nil {
+		return err
+	}
+	if accountName == "" {
+		accountName = s.nextAccount(appName)
+		if accountName == "" {
+			return fmt.Errorf("no accounts configured for %s", appName)
+		}
+	}
+	if !contains(appConfig.Accounts, accountName) {
+		return fmt.Errorf("account '%s' not found for %s", accountName, appName)
+	}
+
+	authPath := expandPath(appConfig.AuthPath)
+	switchPath := resolveSwitchPattern(appConfig.SwitchPattern, appName, authPath, accountName)
+	if _, err := os.Stat(switchPath); err != nil {
+		return fmt.Errorf("switch file not found: %s", switchPath)
+	}
+
+	fmt.Printf("%sDry run: switch %s to %s%s\n", ColorCyan, appName, accountName, ColorReset)
+	for _, lp := range livePaths(appConfig) {
+		if lp.name != "" && !fileOrDirExists(lp.in(switchPath)) {
+			if fileOrDirExists(lp.path) {
+				fmt.Printf("  Remove  %s (not in profile)\n", lp.path)
 			}
+			continue
+		}
+		fmt.Printf("  Replace %s\n", lp.path)
+		fmt.Printf("  From    %s\n", lp.in(switchPath))
+	}
+	if appConfig.PreSwitch != "" {
+		fmt.Printf("  Run     %s (pre_switch)\n", appConfig.PreSwitch)
+	}
+	if appConfig.PostSwitch != "" {
+		fmt.Printf("  Run     %s (post_switch)\n", appConfig.PostSwitch)
+	}
+	var removed []string
+	for _, lp := range livePaths(appConfig) {
+		from := lp.in(switchPath)
+		if !isFolder(from) {
+			continue
+		}
+		filter := profileFilter(appConfig, lp.path)
+		paths, err := extraneousPaths(from, lp.path, filter)
+		if err != nil {
+			return fmt.Errorf("scan %s: %w", lp.path, err)
+		}
+		for _, rel := range paths {
+			removed = append(removed, path.Join(lp.name, rel))
 		}
-		if next == "" {
-			next = appConfig.Accounts[0]
+		if len(appConfig.Include)+len(appConfig.Exclude) > 0 {
+			if kept := filter.unmanaged(lp.path); len(kept) > 0 {
+				fmt.Printf("  Keeps %d unmanaged entries: %s\n", len(kept), strings.Join(kept, ", "))
+			}
 		}
 	}
-	return s.SwitchAccount(appName, next)
+	if !isFolder(switchPath) {
+		return nil
+	}
+	if len(removed) == 0 {
+		fmt.Printf("  No files would be removed\n")
+		return nil
+	}
+	fmt.Printf("  Would remove:\n")
+	for _, rel := range removed {
+		fmt.Printf("    %s-%s %s\n", ColorRed, ColorReset, rel)
+	}
+	return nil
 }
 
 func (s *Switcher) findCurrentAccount(appName string) string {
+	current, _ := s.detectCurrent(appName)
+	return current
+}
+
+// detectCurrent finds the profile that matches the live config. locked is
+// true when encrypted profiles could not be compared because no key is
+// cached; the recorded current profile is then returned unverified.
+func (s *Switcher) detectCurrent(appName string) (current string, locked bool) {
 	appConfig, exists := s.GetAppConfig(appName)
-	if !exists {
-		return ""
+	if !exists || validateEnv(appConfig) != nil {
+		return "", false
 	}
 
-	authPath := expandPath(appConfig.AuthPath)
-	if _, err := os.Stat(authPath); err != nil {
-		return ""
+	if !liveExists(appConfig) {
+		return "", false
 	}
 
+	authPath := expandPath(appConfig.AuthPath)
 	for _, accountName := range appConfig.Accounts {
-		switchPath := resolveSwitchPattern(appConfig.SwitchPattern, authPath, accountName)
+		switchPath := resolveSwitchPattern(appConfig.SwitchPattern, appName, authPath, accountName)
 		if _, err := os.Stat(switchPath); err != nil {
 			continue
 		}
-		if contentEqual(authPath, switchPath) {
-			return accountName
+		equal, known := s.snapshotEqual(appConfig, switchPath)
+		if !known {
+			locked = true
+		} else if equal {
+			return accountName, false
 		}
 	}
-	return ""
+	// Encrypted snapshots cannot be compared while the key is locked, so
+	// trust the recorded current profile.
+	if locked && contains(appConfig.Accounts, appConfig.Current) {
+		return appConfig.Current, true
+	}
+	return "", locked
 }
 
 func (s *Switcher) ListAccounts(appName string) {
@@ -492,15 +1777,29 @@ func (s *Switcher) ListAccounts(appName string) {
 		return
 	}
 
-	current := s.findCurrentAccount(appName)
+	current, locked := s.detectCurrent(appName)
+	drifted, origin := s.checkDrift(appName, current)
 	fmt.Printf("%s%s accounts:%s\n", ColorCyan, strings.Title(appName), ColorReset)
 	for _, acc := range appConfig.Accounts {
-		if acc == current {
+		if acc == current && locked {
+			fmt.Printf("  %s●%s %s %s(current, locked)%s\n", ColorGreen, ColorReset, acc, ColorYellow, ColorReset)
+		} else if acc == current {
 			fmt.Printf("  %s●%s %s %s(current)%s\n", ColorGreen, ColorReset, acc, ColorYellow, ColorReset)
+		} else if drifted && acc == origin {
+			fmt.Printf("  %s●%s %s %s(current, modified)%s\n", ColorYellow, ColorReset, acc, ColorRed, ColorReset)
 		} else {
 			fmt.Printf("  ○ %s\n", acc)
 		}
 	}
+	if drifted && origin == "" {
+		fmt.Printf("  %s! live config has unsaved changes%s\n", ColorRed, ColorReset)
+	}
+	if err := validateEnv(appConfig); err != nil {
+		fmt.Printf("  %s! %v%s\n", ColorRed, err, ColorReset)
+	}
+	if locked {
+		fmt.Printf("  %s! encrypted profiles are locked, so unsaved changes are not detected; run 'eval \"$(switch unlock)\"' to check%s\n", ColorYellow, ColorReset)
+	}
 }
 
 func (s *Switcher) ListAllApps() {
@@ -511,8 +1810,9 @@ func (s *Switcher) ListAllApps() {
 	}
 
 	fmt.Printf("%sConfigured applications:%s\n", ColorCyan, ColorReset)
-	for appName, appConfig := range s.config.Apps {
-		current := s.findCurrentAccount(appName)
+	for _, appName := range s.appNames() {
+		appConfig := s.config.Apps[appName]
+		current, locked := s.detectCurrent(appName)
 		accountCount := len(appConfig.Accounts)
 
 		if appName == s.config.Default.Config {
@@ -521,8 +1821,14 @@ func (s *Switcher) ListAllApps() {
 			fmt.Printf("  ○ %s (%d accounts)", appName, accountCount)
 		}
 
-		if current != "" {
+		if current != "" && locked {
+			fmt.Printf(" - current: %s %s(locked)%s", current, ColorYellow, ColorReset)
+		} else if current != "" {
 			fmt.Printf(" - current: %s", current)
+		} else if drifted, origin := s.checkDrift(appName, current); drifted && origin != "" {
+			fmt.Printf(" - current: %s %s(modified)%s", origin, ColorRed, ColorReset)
+		} else if drifted {
+			fmt.Printf(" - %sunsaved changes%s", ColorRed, ColorReset)
 		}
 		fmt.Printf("\n")
 	}
@@ -549,6 +1855,9 @@ func (s *Switcher) SetDefaultApp(appName string) error {
 }
 
 func (s *Switcher) OpenConfig() error {
+	// The editor may stay open for a long time; don't make other
+	// invocations wait on the config lock meanwhile.
+	s.Close()
 	editor := os.Getenv("EDITOR")
 	if editor == "" {
 		// Try common editors
@@ -570,21 +1879,13 @@ func (s *Switcher) OpenConfig() error {
 	return cmd.Run()
 }
 
-// App detection based on templates
-func DetectApplications() map[string]AppTemplate {
+// App detection based on templates. Detected templates have AuthPath set
+// to the config path found on this machine.
+func DetectApplications(templates map[string]AppTemplate) map[string]AppTemplate {
 	found := make(map[string]AppTemplate)
-	for name, tpl := range AppTemplates {
-		for _, p := range tpl.DetectPaths {
-			p = expandPath(p)
-			if fileOrDirExists(p) {
-				t := tpl
-				t.AuthPath = tpl.AuthPath
-				if !fileOrDirExists(expandPath(tpl.AuthPath)) {
-					t.AuthPath = p
-				}
-				found[name] = t
-				break
-			}
+	for name, tpl := range templates {
+		if t, ok := resolveTemplate(tpl); ok {
+			found[name] = t
 		}
 	}
 	return found
@@ -659,7 +1960,7 @@ func (s *Switcher) RunWizard() error {
 		fmt.Println("└───────────────────────────────────────────────────────────┘")
 		fmt.Println()
 
-		detected := DetectApplications()
+		detected := DetectApplications(s.templates())
 		var keys []string
 		for name := range detected {
 			keys = append(keys, name)
@@ -688,6 +1989,7 @@ func (s *Switcher) RunWizard() error {
 		var appName string
 		var authPath string
 		var pattern string
+		var tpl AppTemplate
 		if idx == len(options)-1 {
 			appName, err = promptString("Application name", "")
 			if err != nil {
@@ -697,19 +1999,14 @@ func (s *Switcher) RunWizard() error {
 			if err != nil {
 				return err
 			}
-			var defPattern string
-			if strings.Contains(filepath.Base(authPath), ".") {
-				defPattern = "{auth_path}.{name}.switch"
-			} else {
-				defPattern = filepath.Join(filepath.Dir(authPath), "profiles", "{name}.switch")
-			}
+			defPattern := centralPattern()
 			pattern, err = promptString("Switch pattern", defPattern)
 			if err != nil {
 				return err
 			}
 		} else {
 			key := keys[idx]
-			tpl := detected[key]
+			tpl = detected[key]
 			appName, err = promptString("Application name", key)
 			if err != nil {
 				return err
@@ -718,7 +2015,7 @@ func (s *Switcher) RunWizard() error {
 			if err != nil {
 				return err
 			}
-			pattern, err = promptString("Switch pattern", tpl.Pattern)
+			pattern, err = promptString("Switch pattern", templatePattern(tpl))
 			if err != nil {
 				return err
 			}
@@ -730,12 +2027,15 @@ func (s *Switcher) RunWizard() error {
 			return err
 		}
 		profile = strings.TrimSpace(profile)
+		if err := validateProfileName(profile); err != nil {
+			return err
+		}
 
 		fmt.Println("\nSummary:")
 		fmt.Printf("  App:         %s\n", appName)
 		fmt.Printf("  Profile:     %s\n", profile)
 		fmt.Printf("  Config path: %s\n", authPath)
-		fmt.Printf("  Backup path: %s\n", resolveSwitchPattern(pattern, authPath, profile))
+		fmt.Printf("  Backup path: %s\n", resolveSwitchPattern(pattern, appName, authPath, profile))
 
 		ok, err := promptYesNo("Save this configuration?", true)
 		if err != nil {
@@ -750,6 +2050,9 @@ func (s *Switcher) RunWizard() error {
 			Accounts:      []string{},
 			AuthPath:      authPath,
 			SwitchPattern: pattern,
+			Include:       tpl.Include,
+			Exclude:       tpl.Exclude,
+			IgnoreKeys:    tpl.IgnoreKeys,
 		})
 		if err := s.AddAccount(appName, profile); err != nil {
 			return err
@@ -793,12 +2096,15 @@ func (s *Switcher) RunWizard() error {
 		if err != nil {
 			return err
 		}
+		if err := validateProfileName(profile); err != nil {
+			return err
+		}
 		fmt.Println("\nSummary:")
 		appCfg := s.config.Apps[appName]
 		fmt.Printf("  App:         %s\n", appName)
 		fmt.Printf("  Profile:     %s\n", profile)
-		fmt.Printf("  Config path: %s\n", expandPath(appCfg.AuthPath))
-		fmt.Printf("  Backup path: %s\n", resolveSwitchPattern(appCfg.SwitchPattern, expandPath(appCfg.AuthPath), profile))
+		fmt.Printf("  Config path: %s\n", liveLabel(appCfg, ", "))
+		fmt.Printf("  Backup path: %s\n", resolveSwitchPattern(appCfg.SwitchPattern, appName, expandPath(appCfg.AuthPath), profile))
 		ok, err := promptYesNo("Save this configuration?", true)
 		if err != nil {
 			return err
@@ -810,7 +2116,7 @@ func (s *Switcher) RunWizard() error {
 	}
 
 	if idx == len(existing) { // auto-detect
-		detected := DetectApplications()
+		detected := DetectApplications(s.templates())
 		var keys []string
 		for name := range detected {
 			if _, exists := s.config.Apps[name]; !exists {
@@ -849,7 +2155,7 @@ func (s *Switcher) RunWizard() error {
 		if err != nil {
 			return err
 		}
-		pattern, err := promptString("Switch pattern", tpl.Pattern)
+		pattern, err := promptString("Switch pattern", templatePattern(tpl))
 		if err != nil {
 			return err
 		}
@@ -857,12 +2163,15 @@ func (s *Switcher) RunWizard() error {
 		if err != nil {
 			return err
 		}
+		if err := validateProfileName(profile); err != nil {
+			return err
+		}
 		authPath = expandPath(authPath)
 		fmt.Println("\nSummary:")
 		fmt.Printf("  App:         %s\n", appName)
 		fmt.Printf("  Profile:     %s\n", profile)
 		fmt.Printf("  Config path: %s\n", authPath)
-		fmt.Printf("  Backup path: %s\n", resolveSwitchPattern(pattern, authPath, profile))
+		fmt.Printf("  Backup path: %s\n", resolveSwitchPattern(pattern, appName, authPath, profile))
 		ok, err := promptYesNo("Save this configuration?", true)
 		if err != nil {
 			return err
@@ -870,7 +2179,7 @@ func (s *Switcher) RunWizard() error {
 		if !ok {
 			return fmt.Errorf("cancelled")
 		}
-		s.SetAppConfig(appName, AppConfig{Current: profile, Accounts: []string{}, AuthPath: authPath, SwitchPattern: pattern})
+		s.SetAppConfig(appName, AppConfig{Current: profile, Accounts: []string{}, AuthPath: authPath, SwitchPattern: pattern, Include: tpl.Include, Exclude: tpl.Exclude, IgnoreKeys: tpl.IgnoreKeys})
 		if err := s.AddAccount(appName, profile); err != nil {
 			return err
 		}
@@ -892,13 +2201,7 @@ func (s *Switcher) RunWizard() error {
 	if err != nil {
 		return err
 	}
-	var defPattern string
-	if strings.Contains(filepath.Base(authPath), ".") {
-		defPattern = "{auth_path}.{name}.switch"
-	} else {
-		defPattern = filepath.Join(filepath.Dir(authPath), "profiles", "{name}.switch")
-	}
-	pattern, err := promptString("Switch pattern", defPattern)
+	pattern, err := promptString("Switch pattern", centralPattern())
 	if err != nil {
 		return err
 	}
@@ -906,12 +2209,15 @@ func (s *Switcher) RunWizard() error {
 	if err != nil {
 		return err
 	}
+	if err := validateProfileName(profile); err != nil {
+		return err
+	}
 	authPath = expandPath(authPath)
 	fmt.Println("\nSummary:")
 	fmt.Printf("  App:         %s\n", appName)
 	fmt.Printf("  Profile:     %s\n", profile)
 	fmt.Printf("  Config path: %s\n", authPath)
-	fmt.Printf("  Backup path: %s\n", resolveSwitchPattern(pattern, authPath, profile))
+	fmt.Printf("  Backup path: %s\n", resolveSwitchPattern(pattern, appName, authPath, profile))
 	ok, err := promptYesNo("Save this configuration?", true)
 	if err != nil {
 		return err
@@ -942,7 +2248,27 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
+// extractFlag removes every occurrence of the given flag names from args and
+// reports whether any was present.
+func extractFlag(args []string, names ...string) ([]string, bool) {
+	var rest []string
+	found := false
+	for _, arg := range args {
+		if contains(names, arg) {
+			found = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, found
+}
+
+// lastError is the most recent error reported through printError, used to
+// fill in the error field of JSON output.
+var lastError error
+
 func printError(err error) {
+	lastError = err
 	fmt.Fprintf(os.Stderr, "%s✗ Error: %v%s\n", ColorRed, err, ColorReset)
 }
 
@@ -965,17 +2291,40 @@ func printHelp() {
 	fmt.Printf("  switch                       Cycle through default app accounts\n")
 	fmt.Printf("  switch <app>                 Cycle through app accounts\n")
 	fmt.Printf("  switch <app> <account>       Switch to specific account\n")
+	fmt.Printf("  switch <app> [account] -n    Show what a switch would change (--dry-run)\n")
+	fmt.Printf("  switch <app> -               Switch back to the previous account\n")
+	fmt.Printf("  switch undo [app]            Revert the last switch\n")
+	fmt.Printf("  switch history [app]         Show recent switches\n")
 	fmt.Printf("  switch add                   Launch setup wizard\n")
 	fmt.Printf("  switch add <app>             Add a profile to app\n")
 	fmt.Printf("  switch add <app> <account>   Add current config as account\n")
+	fmt.Printf("  switch save [app]            Save live config into the current profile\n")
+	fmt.Printf("  switch encrypt [app]         Encrypt an app's profiles with a passphrase\n")
+	fmt.Printf("  switch unlock                Cache the encryption key for this shell\n")
+	fmt.Printf("  switch lock                  Forget the cached encryption key\n")
+	fmt.Printf("  switch migrate-store [app]   Move profiles into the central store\n")
+	fmt.Printf("  switch templates             List app templates and where they come from\n")
+	fmt.Printf("  switch remove <app> <account> Delete a profile (--yes skips confirmation)\n")
+	fmt.Printf("  switch rename <app> <old> <new> Rename a profile\n")
+	fmt.Printf("  switch rename <app> <new>    Rename an app\n")
 	fmt.Printf("  switch list                  List all apps and profiles\n")
 	fmt.Printf("  switch list <app>            List profiles for specific app\n")
+	fmt.Printf("  switch status [app]          Show current profiles and config paths\n")
+	fmt.Printf("  switch context               List contexts\n")
+	fmt.Printf("  switch context <name>        Switch every app in a context\n")
 	fmt.Printf("  switch default <app>         Set default app\n")
 	fmt.Printf("  switch config                Open config file in editor\n")
 	fmt.Printf("  switch <app> config          Open config file in editor\n")
 	fmt.Printf("  switch -v                   Print short version (commit)\n")
 	fmt.Printf("  switch help                 Show this help\n\n")
-	fmt.Printf("Built-in templates: codex, claude, vscode, cursor, ssh, git\n")
+	fmt.Printf("Global flags:\n")
+	fmt.Printf("  --json, --format json        Print machine-readable JSON to stdout\n\n")
+	names := make([]string, 0, len(AppTemplates))
+	for name := range AppTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("Built-in templates: %s\n", strings.Join(names, ", "))
 }
 
 func shortVersion() string {
@@ -995,6 +2344,7 @@ func runDefaultCycle() int {
 		printError(err)
 		return 1
 	}
+	defer s.Close()
 	def := s.config.Default.Config
 	if def == "" {
 		fmt.Printf("%s✗ No default application configured%s\n", ColorRed, ColorReset)
@@ -1042,6 +2392,115 @@ func handleAdd(s *Switcher, args []string) int {
 	}
 }
 
+func handleSave(s *Switcher, args []string) int {
+	var appName string
+	switch len(args) {
+	case 0:
+		appName = s.config.Default.Config
+		if appName == "" {
+			fmt.Printf("%s✗ No default application configured%s\n", ColorRed, ColorReset)
+			return 1
+		}
+	case 1:
+		appName = args[0]
+	default:
+		fmt.Printf("Usage: switch save [app]\n")
+		return 1
+	}
+	if _, err := s.SaveCurrent(appName); err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
+func handleEncrypt(s *Switcher, args []string) int {
+	var appName string
+	switch len(args) {
+	case 0:
+		appName = s.config.Default.Config
+		if appName == "" {
+			fmt.Printf("%s✗ No default application configured%s\n", ColorRed, ColorReset)
+			return 1
+		}
+	case 1:
+		appName = args[0]
+	default:
+		fmt.Printf("Usage: switch encrypt [app]\n")
+		return 1
+	}
+	if err := s.EncryptSnapshots(appName); err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
+func handleUndo(s *Switcher, args []string) int {
+	if len(args) > 1 {
+		fmt.Printf("Usage: switch undo [app]\n")
+		return 1
+	}
+	appName := ""
+	if len(args) == 1 {
+		appName = args[0]
+	}
+	if err := s.Undo(appName); err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
+func handleHistory(s *Switcher, args []string) int {
+	if len(args) > 1 {
+		fmt.Printf("Usage: switch history [app]\n")
+		return 1
+	}
+	appName := ""
+	if len(args) == 1 {
+		appName = args[0]
+	}
+	if err := s.ShowHistory(appName); err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
+func handleStatus(s *Switcher, args []string) int {
+	if len(args) > 1 {
+		fmt.Printf("Usage: switch status [app]\n")
+		return 1
+	}
+	appName := ""
+	if len(args) == 1 {
+		appName = args[0]
+	}
+	if err := s.ShowStatus(appName); err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
+func handleContext(s *Switcher, args []string) int {
+	switch len(args) {
+	case 0:
+		s.ListContexts()
+		return 0
+	case 1:
+		if err := s.ApplyContext(args[0]); err != nil {
+			printError(err)
+			return 1
+		}
+		return 0
+	default:
+		fmt.Printf("Usage: switch context [name]\n")
+		return 1
+	}
+}
+
 func handleList(s *Switcher, args []string) int {
 	if len(args) == 0 {
 		s.ListAllApps()
@@ -1051,7 +2510,58 @@ func handleList(s *Switcher, args []string) int {
 	return 0
 }
 
+func handleRemove(s *Switcher, args []string) int {
+	args, assumeYes := extractFlag(args, "--yes", "-y")
+	if len(args) != 2 {
+		fmt.Printf("Usage: switch remove <app> <account> [--yes]\n")
+		return 1
+	}
+	if err := s.RemoveAccount(args[0], args[1], assumeYes); err != nil {
+		if err.Error() != "cancelled by user" {
+			printError(err)
+		}
+		return 1
+	}
+	return 0
+}
+
+func handleRename(s *Switcher, args []string) int {
+	var err error
+	switch len(args) {
+	case 2:
+		err = s.RenameApp(args[0], args[1])
+	case 3:
+		err = s.RenameAccount(args[0], args[1], args[2])
+	default:
+		fmt.Printf("Usage: switch rename <app> <old> <new>\n")
+		fmt.Printf("       switch rename <app> <new-app>\n")
+		return 1
+	}
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
 func handleApp(s *Switcher, appName string, args []string) int {
+	args, dryRun := extractFlag(args, "--dry-run", "-n")
+	if dryRun {
+		if len(args) > 1 {
+			fmt.Printf("Usage: switch <app> [account] --dry-run\n")
+			return 1
+		}
+		account := ""
+		if len(args) == 1 {
+			account = args[0]
+		}
+		if err := s.PreviewSwitch(appName, account); err != nil {
+			printError(err)
+			return 1
+		}
+		return 0
+	}
+
 	switch len(args) {
 	case 0:
 		if err := s.CycleAccounts(appName); err != nil {
@@ -1069,6 +2579,16 @@ func handleApp(s *Switcher, appName string, args []string) int {
 			s.ListAccounts(appName)
 			return 0
 		}
+		if sub == "save" {
+			return handleSave(s, []string{appName})
+		}
+		if sub == "-" {
+			if err := s.SwitchBack(appName); err != nil {
+				printError(err)
+				return 1
+			}
+			return 0
+		}
 		if sub == "config" {
 			if err := s.OpenConfig(); err != nil {
 				printError(err)
@@ -1089,6 +2609,9 @@ func handleApp(s *Switcher, appName string, args []string) int {
 			}
 			return 0
 		}
+		if args[0] == "remove" {
+			return handleRemove(s, []string{appName, args[1]})
+		}
 		fallthrough
 	default:
 		fmt.Printf("%s✗ Unknown command format%s\n", ColorRed, ColorReset)
@@ -1098,46 +2621,129 @@ func handleApp(s *Switcher, appName string, args []string) int {
 }
 
 func main() {
-	if len(os.Args) == 1 {
-		os.Exit(runDefaultCycle())
+	args, format, err := parseGlobalFlags(os.Args[1:])
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+	if format == "json" {
+		os.Exit(runJSON(args))
+	}
+	os.Exit(run(args))
+}
+
+// parseGlobalFlags strips the output format flags (--json, --format <fmt>,
+// --format=<fmt>) from args and returns the remaining arguments.
+func parseGlobalFlags(args []string) ([]string, string, error) {
+	format := "text"
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--json":
+			format = "json"
+		case arg == "--format":
+			if i+1 >= len(args) {
+				return nil, "", fmt.Errorf("--format requires a value (text or json)")
+			}
+			i++
+			format = args[i]
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if format != "text" && format != "json" {
+		return nil, "", fmt.Errorf("unknown format '%s' (expected text or json)", format)
+	}
+	return rest, format, nil
+}
+
+// run executes a command line (without the program name) and returns the
+// process exit code.
+func run(args []string) int {
+	if len(args) == 0 {
+		return runDefaultCycle()
 	}
-	if len(os.Args) == 2 && (os.Args[1] == "-v" || os.Args[1] == "--version") {
+	if len(args) == 1 && (args[0] == "-v" || args[0] == "--version") {
 		fmt.Println(shortVersion())
-		return
+		return 0
 	}
 
 	s, err := NewSwitcher()
 	if err != nil {
 		printError(err)
-		os.Exit(1)
+		return 1
 	}
+	defer s.Close()
 
-	switch os.Args[1] {
+	switch args[0] {
 	case "version":
 		fmt.Println(shortVersion())
-		return
 	case "add":
-		os.Exit(handleAdd(s, os.Args[2:]))
+		return handleAdd(s, args[1:])
 	case "list":
-		os.Exit(handleList(s, os.Args[2:]))
+		return handleList(s, args[1:])
+	case "status":
+		return handleStatus(s, args[1:])
+	case "remove", "rm":
+		return handleRemove(s, args[1:])
+	case "save":
+		return handleSave(s, args[1:])
+	case "encrypt":
+		return handleEncrypt(s, args[1:])
+	case "migrate-store":
+		if len(args) > 2 {
+			fmt.Printf("Usage: switch migrate-store [app]\n")
+			return 1
+		}
+		appName := ""
+		if len(args) == 2 {
+			appName = args[1]
+		}
+		if err := s.MigrateStore(appName); err != nil {
+			printError(err)
+			return 1
+		}
+	case "templates":
+		s.ListTemplates()
+	case "unlock":
+		if err := s.UnlockKeys(); err != nil {
+			printError(err)
+			return 1
+		}
+	case "lock":
+		if err := s.LockKeys(); err != nil {
+			printError(err)
+			return 1
+		}
+	case "undo":
+		return handleUndo(s, args[1:])
+	case "history":
+		return handleHistory(s, args[1:])
+	case "rename", "mv":
+		return handleRename(s, args[1:])
+	case "context", "ctx":
+		return handleContext(s, args[1:])
 	case "default":
-		if len(os.Args) != 3 {
+		if len(args) != 2 {
 			fmt.Printf("Usage: switch default <app>\n")
-			os.Exit(1)
+			return 1
 		}
-		if err := s.SetDefaultApp(os.Args[2]); err != nil {
+		if err := s.SetDefaultApp(args[1]); err != nil {
 			printError(err)
-			os.Exit(1)
+			return 1
 		}
 	case "config":
 		if err := s.OpenConfig(); err != nil {
 			printError(err)
-			os.Exit(1)
+			return 1
 		}
 	case "help":
 		printHelp()
 	default:
-		app := os.Args[1]
-		os.Exit(handleApp(s, app, os.Args[2:]))
+		return handleApp(s, args[0], args[1:])
 	}
+	return 0
 }
@@ -20,6 +20,8 @@ func setHome(t *testing.T) string {
 	t.Helper()
 	temp := t.TempDir()
 	t.Setenv("HOME", temp)
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
 	// On Windows, os.UserHomeDir() uses USERPROFILE, not HOME
 	if runtime.GOOS == "windows" {
 		t.Setenv("USERPROFILE", temp)
@@ -206,6 +208,62 @@ func TestLoadSaveConfig_RoundTrip(t *testing.T) {
 	}
 }
 
+func TestSaveConfig_AtomicKeepsPermissions(t *testing.T) {
+	home := setHome(t)
+	s, err := newTestSwitcher(t, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(s.configPath, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s.config.Apps["codex"] = AppConfig{Current: "u1", Accounts: []string{"u1"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"}
+	if err := s.saveConfig(); err != nil {
+		t.Fatalf("saveConfig: %v", err)
+	}
+	if runtime.GOOS != "windows" {
+		if info, _ := os.Stat(s.configPath); info.Mode().Perm() != 0600 {
+			t.Fatalf("config permissions not preserved: %v", info.Mode().Perm())
+		}
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(home, stagePrefix(s.configPath)+"*"))
+	if len(leftovers) != 0 {
+		t.Fatalf("temp config files left behind: %v", leftovers)
+	}
+	data, _ := os.ReadFile(s.configPath)
+	if !strings.Contains(string(data), "[apps.codex]") {
+		t.Fatalf("config not written: %s", string(data))
+	}
+}
+
+func TestSaveConfig_KeepsSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+	home := setHome(t)
+	target := filepath.Join(home, "dotfiles", "switch.toml")
+	os.MkdirAll(filepath.Dir(target), 0755)
+	os.WriteFile(target, nil, 0644)
+	link := filepath.Join(home, ".switch.toml")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newTestSwitcher(t, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.config.Apps["codex"] = AppConfig{Current: "u1", Accounts: []string{"u1"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"}
+	if err := s.saveConfig(); err != nil {
+		t.Fatalf("saveConfig: %v", err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("config symlink replaced by a regular file")
+	}
+	if data, _ := os.ReadFile(target); !strings.Contains(string(data), "[apps.codex]") {
+		t.Fatalf("link target not written: %s", data)
+	}
+}
+
 // Path and copy utilities
 func TestExpandAndResolve(t *testing.T) {
 	home := setHome(t)
@@ -215,7 +273,7 @@ func TestExpandAndResolve(t *testing.T) {
 	if filepath.Clean(got) != filepath.Clean(want) {
 		t.Errorf("expandPath mismatch: got %s, want %s", got, want)
 	}
-	p := resolveSwitchPattern("{auth_path}.{name}.switch", filepath.Join(home, ".codex/auth.json"), "alice")
+	p := resolveSwitchPattern("{auth_path}.{name}.switch", "codex", filepath.Join(home, ".codex/auth.json"), "alice")
 	if !strings.HasSuffix(p, ".codex/auth.json.alice.switch") {
 		t.Errorf("resolveSwitchPattern unexpected: %s", p)
 	}
@@ -233,7 +291,7 @@ func TestExpandAndResolve_WindowsLikePaths(t *testing.T) {
 	// Pattern and auth path using backslashes normalize to slash form and resolve correctly
 	auth := "~\\.codex\\auth.json"
 	pat := "{auth_path}\\{name}.switch"
-	out := resolveSwitchPattern(pat, expandPath(auth), "alice")
+	out := resolveSwitchPattern(pat, "codex", expandPath(auth), "alice")
 	if !strings.HasSuffix(out, ".codex/auth.json/alice.switch") && !strings.HasSuffix(out, ".codex/auth.json.alice.switch") {
 		t.Errorf("resolveSwitchPattern windows-like unexpected: %s", out)
 	}
@@ -246,6 +304,56 @@ func TestExpandAndResolve_WindowsLikePaths(t *testing.T) {
 	}
 }
 
+func TestResolveSwitchPattern_Placeholders(t *testing.T) {
+	home := setHome(t)
+	t.Setenv("SWITCH_TEST_STORE", filepath.Join(home, "sync"))
+	authPath := expandPath("~/.config/gh/hosts.yml")
+	cases := map[string]string{
+		"$SWITCH_TEST_STORE/{app}/{name}":         filepath.Join(home, "sync", "gh", "work"),
+		"${SWITCH_TEST_STORE}/{auth_base}.{name}": filepath.Join(home, "sync", "hosts.yml.work"),
+		"{auth_dir}/profiles/{name}":              filepath.Join(home, ".config", "gh", "profiles", "work"),
+		"~/p/{host}/{user}/{name}":                filepath.Join(home, "p", patternHost(), patternUser(), "work"),
+	}
+	for pattern, want := range cases {
+		if got := resolveSwitchPattern(pattern, "gh", authPath, "work"); got != filepath.ToSlash(want) {
+			t.Errorf("resolveSwitchPattern(%q) = %q, want %q", pattern, got, filepath.ToSlash(want))
+		}
+	}
+	// Substituted values are taken as they are
+	if got := resolveSwitchPattern("~/p/{name}", "gh", authPath, "{app}$HOME"); got != filepath.ToSlash(filepath.Join(home, "p", "{app}$HOME")) {
+		t.Errorf("profile name was expanded: %q", got)
+	}
+	if patternHost() == "" || strings.Contains(patternHost(), ".") {
+		t.Errorf("unexpected host name %q", patternHost())
+	}
+}
+
+func TestExpandPath_Env(t *testing.T) {
+	home := setHome(t)
+	t.Setenv("SWITCH_TEST_DIR", filepath.Join(home, "tools"))
+	t.Setenv("SWITCH_TEST_EMPTY", "")
+	cases := map[string]string{
+		"$SWITCH_TEST_DIR/auth.json":                 filepath.Join(home, "tools", "auth.json"),
+		"${SWITCH_TEST_DIR}/auth.json":               filepath.Join(home, "tools", "auth.json"),
+		"${SWITCH_TEST_EMPTY:-~/.config}/Code/User":  filepath.Join(home, ".config", "Code", "User"),
+		"${SWITCH_TEST_DIR:-~/.config}/Code/User":    filepath.Join(home, "tools", "Code", "User"),
+		"${SWITCH_TEST_EMPTY:-${SWITCH_TEST_DIR}}/x": filepath.Join(home, "tools", "x"),
+		"~/$SWITCH_TEST_UNSET/x":                     filepath.Join(home, "$SWITCH_TEST_UNSET", "x"),
+		"~/price$":                                   filepath.Join(home, "price$"),
+	}
+	for in, want := range cases {
+		if got := expandPath(in); got != filepath.ToSlash(want) {
+			t.Errorf("expandPath(%q) = %q, want %q", in, got, filepath.ToSlash(want))
+		}
+	}
+	if _, ok := expandEnv("${SWITCH_TEST_UNSET}/auth.json"); ok {
+		t.Errorf("expected unset variable to be reported")
+	}
+	if _, ok := expandEnv("${SWITCH_TEST_UNSET:-~}/auth.json"); !ok {
+		t.Errorf("expected default to satisfy unset variable")
+	}
+}
+
 func TestCopyFileFolderAndPath(t *testing.T) {
 	setHome(t)
 	base := t.TempDir()
@@ -318,6 +426,189 @@ func TestCopyPreservesPermissions_FileAndDir(t *testing.T) {
 	}
 }
 
+func TestReplacePath_FileLeavesNoStage(t *testing.T) {
+	setHome(t)
+	base := t.TempDir()
+	src := filepath.Join(base, "new.json")
+	dst := filepath.Join(base, "auth.json")
+	os.WriteFile(src, []byte(`{"token":"new"}`), 0600)
+	os.WriteFile(dst, []byte(`{"token":"old"}`), 0644)
+	if err := replacePath(src, dst); err != nil {
+		t.Fatalf("replacePath: %v", err)
+	}
+	b, _ := os.ReadFile(dst)
+	if string(b) != `{"token":"new"}` {
+		t.Fatalf("dst not replaced: %s", string(b))
+	}
+	if runtime.GOOS != "windows" {
+		if info, _ := os.Stat(dst); info.Mode().Perm() != 0600 {
+			t.Fatalf("expected src perms 0600, got %v", info.Mode().Perm())
+		}
+	}
+	entries, _ := os.ReadDir(base)
+	if len(entries) != 2 {
+		t.Fatalf("expected only src and dst after replace, got %d entries", len(entries))
+	}
+}
+
+func TestReplacePath_RefusesToChangeKind(t *testing.T) {
+	setHome(t)
+	base := t.TempDir()
+	file := filepath.Join(base, "auth.json")
+	folder := filepath.Join(base, "store")
+	os.WriteFile(file, []byte(`{"token":"new"}`), 0600)
+	os.MkdirAll(filepath.Join(folder, "a"), 0755)
+	os.WriteFile(filepath.Join(folder, "a", "auth.json"), []byte(`{"token":"a"}`), 0600)
+	if err := replacePath(file, folder); err == nil {
+		t.Fatalf("expected replacing a folder with a file to fail")
+	}
+	if b, _ := os.ReadFile(filepath.Join(folder, "a", "auth.json")); string(b) != `{"token":"a"}` {
+		t.Fatalf("folder content lost: %q", b)
+	}
+	if err := replacePath(folder, file); err == nil {
+		t.Fatalf("expected replacing a file with a folder to fail")
+	}
+	if b, _ := os.ReadFile(file); string(b) != `{"token":"new"}` {
+		t.Fatalf("file content lost: %q", b)
+	}
+	entries, _ := os.ReadDir(base)
+	if len(entries) != 2 {
+		t.Fatalf("expected no stage left behind, got %d entries", len(entries))
+	}
+}
+
+func TestReplacePath_FolderSwapKeepsNestedEntries(t *testing.T) {
+	setHome(t)
+	base := t.TempDir()
+	src := filepath.Join(base, "profile")
+	dst := filepath.Join(base, "live")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "config"), []byte("new"), 0644)
+	os.MkdirAll(filepath.Join(dst, "profiles", "a.switch"), 0755)
+	os.WriteFile(filepath.Join(dst, "profiles", "a.switch", "config"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dst, "config"), []byte("old"), 0644)
+	os.WriteFile(filepath.Join(dst, "id_work"), []byte("key"), 0600)
+	if err := replacePath(src, dst, "profiles"); err != nil {
+		t.Fatalf("replacePath folder: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dst, "config")); string(b) != "new" {
+		t.Fatalf("config not switched: %q", string(b))
+	}
+	if _, err := os.Stat(filepath.Join(dst, "id_work")); !os.IsNotExist(err) {
+		t.Fatalf("expected folder content to be swapped wholesale, err=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "profiles", "a.switch", "config")); err != nil {
+		t.Fatalf("nested store not carried over: %v", err)
+	}
+	entries, _ := os.ReadDir(base)
+	if len(entries) != 2 {
+		t.Fatalf("expected no stage or aside leftovers, got %d entries", len(entries))
+	}
+}
+
+func TestReplacePath_CopyErrorLeavesDestination(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on Windows")
+	}
+	setHome(t)
+	base := t.TempDir()
+	src := filepath.Join(base, "profile")
+	dst := filepath.Join(base, "live")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "a"), []byte("new"), 0644)
+	if err := os.Symlink(filepath.Join(base, "missing"), filepath.Join(src, "z")); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(dst, 0755)
+	os.WriteFile(filepath.Join(dst, "a"), []byte("old"), 0644)
+	if err := replacePath(src, dst); err == nil {
+		t.Fatalf("expected copy error for dangling symlink")
+	}
+	if b, _ := os.ReadFile(filepath.Join(dst, "a")); string(b) != "old" {
+		t.Fatalf("destination modified by failed replace: %q", string(b))
+	}
+	entries, _ := os.ReadDir(base)
+	if len(entries) != 2 {
+		t.Fatalf("expected stage removed after failure, got %d entries", len(entries))
+	}
+}
+
+func TestRecoverSwap(t *testing.T) {
+	setHome(t)
+	base := t.TempDir()
+	dst := filepath.Join(base, "live")
+	// Crash after the old folder was moved aside: restore it.
+	aside := filepath.Join(base, asidePrefix(dst)+"1")
+	os.MkdirAll(aside, 0755)
+	os.WriteFile(filepath.Join(aside, "config"), []byte("old"), 0644)
+	stage := filepath.Join(base, stagePrefix(dst)+"1")
+	os.MkdirAll(stage, 0755)
+	if err := recoverSwap(dst, pathFilter{}); err != nil {
+		t.Fatalf("recoverSwap: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dst, "config")); string(b) != "old" {
+		t.Fatalf("old folder not restored: %q", string(b))
+	}
+	if fileOrDirExists(stage) || fileOrDirExists(aside) {
+		t.Fatalf("leftovers not cleaned up")
+	}
+	// Crash after the new folder was renamed in: carry keep entries over.
+	os.MkdirAll(filepath.Join(aside, "profiles"), 0755)
+	os.WriteFile(filepath.Join(aside, "profiles", "p"), []byte("p"), 0644)
+	if err := recoverSwap(dst, pathFilter{stores: []string{"profiles"}}); err != nil {
+		t.Fatalf("recoverSwap keep: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "profiles", "p")); err != nil {
+		t.Fatalf("keep entry not carried over: %v", err)
+	}
+	if fileOrDirExists(aside) {
+		t.Fatalf("aside not removed")
+	}
+}
+
+func TestNestedStorePaths(t *testing.T) {
+	home := setHome(t)
+	ssh := expandPath("~/.ssh")
+	cfg := AppConfig{Accounts: []string{"a", "b"}, AuthPath: "~/.ssh", SwitchPattern: "~/.ssh/profiles/{name}.switch"}
+	if got := nestedStorePaths(cfg, ssh); len(got) != 1 || got[0] != "profiles" {
+		t.Fatalf("unexpected nested store paths: %v", got)
+	}
+	cfg.SwitchPattern = filepath.Join(home, "store", "{name}")
+	if got := nestedStorePaths(cfg, ssh); len(got) != 0 {
+		t.Fatalf("expected no nested store, got %v", got)
+	}
+}
+
+func TestCopyFolder_MirrorsSource(t *testing.T) {
+	setHome(t)
+	base := t.TempDir()
+	src := filepath.Join(base, "work")
+	dst := filepath.Join(base, "ssh")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "config"), []byte("Host personal"), 0644)
+	os.MkdirAll(filepath.Join(dst, "keys"), 0755)
+	os.WriteFile(filepath.Join(dst, "config"), []byte("Host work"), 0644)
+	os.WriteFile(filepath.Join(dst, "id_work"), []byte("secret"), 0600)
+	os.WriteFile(filepath.Join(dst, "keys", "a"), []byte("a"), 0600)
+
+	extra, err := extraneousPaths(src, dst, pathFilter{})
+	if err != nil {
+		t.Fatalf("extraneousPaths: %v", err)
+	}
+	if strings.Join(extra, ",") != "id_work,keys" {
+		t.Fatalf("unexpected extraneous paths: %v", extra)
+	}
+	if err := copyFolder(src, dst); err != nil {
+		t.Fatalf("copyFolder: %v", err)
+	}
+	if !folderEqual(src, dst) {
+		t.Fatalf("dst should mirror src after copyFolder")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "id_work")); !os.IsNotExist(err) {
+		t.Fatalf("stale id_work not removed, err=%v", err)
+	}
+}
+
 func TestCopyFile_Errors(t *testing.T) {
 	// Nonexistent src triggers early error path
 	if err := copyFile("/no/such/src", t.TempDir()+"/x"); err == nil {
@@ -364,7 +655,7 @@ func TestEqualFunctions(t *testing.T) {
 	if !fileEqual(t1, t2) {
 		t.Errorf("fileEqual text should be true")
 	}
-	// folderEqual only checks both are directories
+	// folderEqual treats two empty directories as equal
 	d1 := filepath.Join(dir, "d1")
 	d2 := filepath.Join(dir, "d2")
 	os.MkdirAll(d1, 0755)
@@ -381,6 +672,65 @@ func TestEqualFunctions(t *testing.T) {
 	}
 }
 
+func TestFolderEqual_ComparesContent(t *testing.T) {
+	setHome(t)
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	for _, root := range []string{a, b} {
+		os.MkdirAll(filepath.Join(root, "snippets"), 0755)
+		os.WriteFile(filepath.Join(root, "settings.json"), []byte(`{"theme":"dark"}`), 0644)
+		os.WriteFile(filepath.Join(root, "snippets", "go.json"), []byte(`{}`), 0644)
+	}
+	if !folderEqual(a, b) {
+		t.Fatalf("identical trees should be equal")
+	}
+	// Different content in a nested file
+	os.WriteFile(filepath.Join(b, "snippets", "go.json"), []byte(`{"x":1}`), 0644)
+	if folderEqual(a, b) {
+		t.Fatalf("trees with different content should differ")
+	}
+	os.WriteFile(filepath.Join(b, "snippets", "go.json"), []byte(`{}`), 0644)
+	// Extra file
+	os.WriteFile(filepath.Join(b, "id_work"), []byte("key"), 0600)
+	if folderEqual(a, b) {
+		t.Fatalf("trees with different file sets should differ")
+	}
+	os.Remove(filepath.Join(b, "id_work"))
+	if !folderEqual(a, b) {
+		t.Fatalf("trees should be equal again after removing extra file")
+	}
+	if runtime.GOOS != "windows" {
+		os.Chmod(filepath.Join(b, "settings.json"), 0600)
+		if folderEqual(a, b) {
+			t.Fatalf("trees with different modes should differ")
+		}
+	}
+}
+
+func TestFindCurrentAccount_FolderApp(t *testing.T) {
+	home := setHome(t)
+	live := filepath.Join(home, ".vscode", "User")
+	os.MkdirAll(live, 0755)
+	os.WriteFile(filepath.Join(live, "settings.json"), []byte("b"), 0644)
+	for _, name := range []string{"a", "b", "c"} {
+		p := filepath.Join(home, ".vscode", "profiles", name+".switch")
+		os.MkdirAll(p, 0755)
+		os.WriteFile(filepath.Join(p, "settings.json"), []byte(name), 0644)
+	}
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("vscode", AppConfig{Current: "b", Accounts: []string{"a", "b", "c"}, AuthPath: "~/.vscode/User", SwitchPattern: "~/.vscode/profiles/{name}.switch"})
+	if cur := s.findCurrentAccount("vscode"); cur != "b" {
+		t.Fatalf("expected current b, got %q", cur)
+	}
+	if err := s.CycleAccounts("vscode"); err != nil {
+		t.Fatalf("CycleAccounts: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(live, "settings.json")); string(got) != "c" {
+		t.Fatalf("expected cycle to c, got %q", string(got))
+	}
+}
+
 func TestFileEqual_NonJSON_NotEqual(t *testing.T) {
 	setHome(t)
 	dir := t.TempDir()
@@ -428,14 +778,21 @@ func TestAddAccount_NewTemplateApp(t *testing.T) {
 	if err := s.AddAccount("codex", "alice"); err != nil {
 		t.Fatalf("AddAccount: %v", err)
 	}
-	// switch file created and config updated
-	if _, err := os.Stat(authPath + ".alice.switch"); err != nil {
+	// snapshot created in the central store and config updated
+	b, err := os.ReadFile(filepath.Join(home, ".local", "share", "switch", "codex", "alice"))
+	if err != nil || string(b) != `{"token":"t123"}` {
 		t.Fatalf("switch backup missing: %v", err)
 	}
+	if fileOrDirExists(authPath + ".alice.switch") {
+		t.Fatalf("snapshot written next to the live config")
+	}
 	app, ok := s.GetAppConfig("codex")
 	if !ok {
 		t.Fatalf("app not set")
 	}
+	if app.SwitchPattern != "~/.local/share/switch/{app}/{name}" {
+		t.Fatalf("unexpected pattern: %s", app.SwitchPattern)
+	}
 	if app.Current != "alice" || !contains(app.Accounts, "alice") {
 		t.Fatalf("config not updated correctly: %+v", app)
 	}
@@ -462,6 +819,24 @@ func TestAddAccount_Duplicate_NoOverwrite(t *testing.T) {
 	}
 }
 
+func TestAddAccount_RejectsPathNames(t *testing.T) {
+	home := setHome(t)
+	setupCodexFiles(t, home, `{"token":"new"}`, nil)
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "~/store/{app}/{name}"})
+	stored := filepath.Join(home, "store", "codex", "a")
+	os.MkdirAll(filepath.Dir(stored), 0755)
+	os.WriteFile(stored, []byte(`{"token":"a"}`), 0600)
+	for _, name := range []string{"", " ", ".", "..", "a/b", `a\b`} {
+		if err := s.AddAccount("codex", name); err == nil {
+			t.Errorf("expected %q to be rejected", name)
+		}
+	}
+	if b, _ := os.ReadFile(stored); string(b) != `{"token":"a"}` {
+		t.Fatalf("existing snapshot lost: %q", b)
+	}
+}
+
 func TestAddAccount_SaveConfigError_RollsBack(t *testing.T) {
 	home := setHome(t)
 	authPath := setupCodexFiles(t, home, `{"token":"z"}`, map[string]string{})
@@ -478,6 +853,65 @@ func TestAddAccount_SaveConfigError_RollsBack(t *testing.T) {
 	}
 }
 
+func TestSwitchAccount_SaveConfigError_Reverts(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	// Force saveConfig error
+	s.configPath = t.TempDir()
+	if err := s.SwitchAccount("codex", "b"); err == nil {
+		t.Fatalf("expected error from saveConfig")
+	}
+	if b, _ := os.ReadFile(authPath); string(b) != `{"token":"a"}` {
+		t.Fatalf("switch not reverted: %s", b)
+	}
+	if app, _ := s.GetAppConfig("codex"); app.Current != "a" {
+		t.Fatalf("current changed to %q", app.Current)
+	}
+	if entries, _ := s.loadHistory(); len(entries) != 0 {
+		t.Fatalf("reverted switch still recorded: %+v", entries)
+	}
+}
+
+func TestSwitchAccount_KeepsSymlinkedConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+	home := setHome(t)
+	target := filepath.Join(home, "dotfiles", "gitconfig")
+	os.MkdirAll(filepath.Dir(target), 0755)
+	os.WriteFile(target, []byte("[user]\n\tname = work\n"), 0644)
+	link := filepath.Join(home, ".gitconfig")
+	if err := os.Symlink(filepath.Join("dotfiles", "gitconfig"), link); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("git", AppConfig{Accounts: []string{}, AuthPath: "~/.gitconfig", SwitchPattern: "~/profiles/{app}/{name}"})
+	if err := s.AddAccount("git", "work"); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(target, []byte("[user]\n\tname = home\n"), 0644)
+	if err := s.AddAccount("git", "home"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SwitchAccount("git", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("symlink replaced by a regular file")
+	}
+	if b, _ := os.ReadFile(target); string(b) != "[user]\n\tname = work\n" {
+		t.Fatalf("link target not switched: %q", b)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(target)); len(entries) != 1 {
+		t.Fatalf("stage left next to the target: %v", entries)
+	}
+	if cur := s.findCurrentAccount("git"); cur != "work" {
+		t.Fatalf("expected current work, got %q", cur)
+	}
+}
+
 func TestSwitchAccount_Success(t *testing.T) {
 	home := setHome(t)
 	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
@@ -495,6 +929,85 @@ func TestSwitchAccount_Success(t *testing.T) {
 	}
 }
 
+func TestSwitchAccount_FolderApp_NestedStore(t *testing.T) {
+	home := setHome(t)
+	ssh := filepath.Join(home, ".ssh")
+	os.MkdirAll(filepath.Join(ssh, "profiles", "work.switch"), 0755)
+	os.MkdirAll(filepath.Join(ssh, "profiles", "home.switch"), 0755)
+	os.WriteFile(filepath.Join(ssh, "config"), []byte("Host work"), 0644)
+	os.WriteFile(filepath.Join(ssh, "profiles", "work.switch", "config"), []byte("Host work"), 0644)
+	os.WriteFile(filepath.Join(ssh, "profiles", "home.switch", "config"), []byte("Host home"), 0644)
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("ssh", AppConfig{Current: "work", Accounts: []string{"home", "work"}, AuthPath: "~/.ssh", SwitchPattern: "~/.ssh/profiles/{name}.switch"})
+	if err := s.SwitchAccount("ssh", "home"); err != nil {
+		t.Fatalf("SwitchAccount folder: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(ssh, "config")); string(b) != "Host home" {
+		t.Fatalf("ssh config not switched: %q", string(b))
+	}
+	for _, name := range []string{"work", "home"} {
+		if _, err := os.Stat(filepath.Join(ssh, "profiles", name+".switch", "config")); err != nil {
+			t.Fatalf("profile %s lost during switch: %v", name, err)
+		}
+	}
+}
+
+func TestAddAccount_FirstProfileSkipsNestedStore(t *testing.T) {
+	home := setHome(t)
+	ssh := filepath.Join(home, ".ssh")
+	os.MkdirAll(filepath.Join(ssh, "profiles", "old.switch"), 0755)
+	os.WriteFile(filepath.Join(ssh, "config"), []byte("Host work"), 0644)
+	os.WriteFile(filepath.Join(ssh, "profiles", "old.switch", "config"), []byte("Host old"), 0644)
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("ssh", AppConfig{Accounts: []string{}, AuthPath: "~/.ssh", SwitchPattern: "~/.ssh/profiles/{name}.switch"})
+	if err := s.AddAccount("ssh", "work"); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(ssh, "profiles", "work.switch")
+	if b, _ := os.ReadFile(filepath.Join(snapshot, "config")); string(b) != "Host work" {
+		t.Fatalf("config not stored: %q", b)
+	}
+	if fileOrDirExists(filepath.Join(snapshot, "profiles")) {
+		t.Fatalf("nested store copied into the first snapshot")
+	}
+}
+
+func TestPreviewSwitch_ListsRemovals(t *testing.T) {
+	home := setHome(t)
+	ssh := filepath.Join(home, ".ssh")
+	os.MkdirAll(filepath.Join(ssh, "profiles", "home.switch"), 0755)
+	os.WriteFile(filepath.Join(ssh, "config"), []byte("Host work"), 0644)
+	os.WriteFile(filepath.Join(ssh, "id_work"), []byte("key"), 0600)
+	os.WriteFile(filepath.Join(ssh, "profiles", "home.switch", "config"), []byte("Host home"), 0644)
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("ssh", AppConfig{Current: "", Accounts: []string{"home"}, AuthPath: "~/.ssh", SwitchPattern: "~/.ssh/profiles/{name}.switch"})
+	out, _ := captureOutput(t, func() {
+		if code := handleApp(s, "ssh", []string{"home", "--dry-run"}); code != 0 {
+			t.Fatalf("dry run failed: %d", code)
+		}
+	})
+	if !strings.Contains(out, "Would remove:") || !strings.Contains(out, "id_work") {
+		t.Fatalf("dry run should list id_work: %q", out)
+	}
+	if strings.Contains(out, " profiles\n") {
+		t.Fatalf("nested profile store must not be listed for removal: %q", out)
+	}
+	if b, _ := os.ReadFile(filepath.Join(ssh, "config")); string(b) != "Host work" {
+		t.Fatalf("dry run modified live config: %q", string(b))
+	}
+	if _, err := os.Stat(filepath.Join(ssh, "id_work")); err != nil {
+		t.Fatalf("dry run removed a file: %v", err)
+	}
+	// Cycling dry run resolves the next account
+	out2, _ := captureOutput(t, func() { _ = handleApp(s, "ssh", []string{"-n"}) })
+	if !strings.Contains(out2, "switch ssh to home") {
+		t.Fatalf("cycle dry run should target home: %q", out2)
+	}
+	if code := handleApp(s, "ssh", []string{"missing", "-n"}); code != 1 {
+		t.Fatalf("expected 1 for unknown account in dry run, got %d", code)
+	}
+}
+
 func TestSwitchAccount_SameAccount(t *testing.T) {
 	home := setHome(t)
 	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`})
@@ -564,6 +1077,45 @@ func TestFindCurrentAccount(t *testing.T) {
 	}
 }
 
+func TestUnsetEnv_RejectedByEveryCommand(t *testing.T) {
+	home := setHome(t)
+	// An unset variable must not be read as a path under the working directory.
+	dir := t.TempDir()
+	t.Chdir(dir)
+	os.MkdirAll(filepath.Join(dir, "$SWITCH_TEST_UNSET"), 0755)
+	os.WriteFile(filepath.Join(dir, "$SWITCH_TEST_UNSET", "auth.json"), []byte(`{"token":"a"}`), 0600)
+	os.WriteFile(filepath.Join(dir, "$SWITCH_TEST_UNSET", "auth.json.a.switch"), []byte(`{"token":"a"}`), 0600)
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b"}, AuthPath: "$SWITCH_TEST_UNSET/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+
+	if cur := s.findCurrentAccount("codex"); cur != "" {
+		t.Errorf("findCurrentAccount = %q, want none", cur)
+	}
+	if drifted, _ := s.checkDrift("codex", ""); drifted {
+		t.Errorf("expected no drift to be reported")
+	}
+	if _, err := s.SaveCurrent("codex"); err == nil {
+		t.Errorf("SaveCurrent: expected error")
+	}
+	checks := map[string]error{
+		"SwitchAccount": s.SwitchAccount("codex", "b"),
+		"PreviewSwitch": s.PreviewSwitch("codex", "b"),
+		"RemoveAccount": s.RemoveAccount("codex", "a", true),
+		"RenameAccount": s.RenameAccount("codex", "a", "c"),
+		"RenameApp":     s.RenameApp("codex", "openai"),
+		"MigrateStore":  s.MigrateStore("codex"),
+		"CycleAccounts": s.CycleAccounts("codex"),
+	}
+	for name, err := range checks {
+		if err == nil || !strings.Contains(err.Error(), "environment variable is not set") {
+			t.Errorf("%s: got %v, want an unset variable error", name, err)
+		}
+	}
+	if !fileOrDirExists(filepath.Join(dir, "$SWITCH_TEST_UNSET", "auth.json.a.switch")) {
+		t.Errorf("snapshot under the working directory was touched")
+	}
+}
+
 func TestRunWizard_ManualSetup_Success(t *testing.T) {
 	home := setHome(t)
 	// Prepare a real auth file
@@ -600,11 +1152,29 @@ func TestRunWizard_ManualSetup_Success(t *testing.T) {
 		t.Fatalf("current not set: %+v", app)
 	}
 	// Backup file created
-	if _, err := os.Stat(resolveSwitchPattern(app.SwitchPattern, authPath, "acc1")); err != nil {
+	if _, err := os.Stat(resolveSwitchPattern(app.SwitchPattern, "myapp", authPath, "acc1")); err != nil {
 		t.Fatalf("backup not created: %v", err)
 	}
 }
 
+func TestRunWizard_ManualSetup_EmptyProfile(t *testing.T) {
+	home := setHome(t)
+	authPath := filepath.Join(home, ".myapp", "cfg.json")
+	os.MkdirAll(filepath.Dir(authPath), 0755)
+	os.WriteFile(authPath, []byte(`{"k":1}`), 0644)
+
+	s, _ := newTestSwitcher(t, home)
+	inputs := strings.Join([]string{"1", "myapp", authPath, "", "", "", ""}, "\n") + "\n"
+	withStdin(t, inputs, func() {
+		if err := s.RunWizard(); err == nil || !strings.Contains(err.Error(), "must not be empty") {
+			t.Fatalf("expected empty profile name error, got %v", err)
+		}
+	})
+	if _, ok := s.GetAppConfig("myapp"); ok {
+		t.Fatalf("app should not be configured")
+	}
+}
+
 func TestLoadConfig_ReadError(t *testing.T) {
 	home := setHome(t)
 	s := &Switcher{configPath: home} // directory path causes read error
@@ -702,22 +1272,32 @@ func TestListAllApps_Empty(t *testing.T) {
 
 func TestDetectApplications(t *testing.T) {
 	home := setHome(t)
-	// Create only Code/User (second detect path), not ~/.vscode/User
-	vscodeAlt := filepath.Join(home, "Library", "Application Support", "Code", "User")
+	// Create only the platform's Code/User folder, not ~/.vscode/User
+	var vscodeAlt string
+	switch runtime.GOOS {
+	case "darwin":
+		vscodeAlt = filepath.Join(home, "Library", "Application Support", "Code", "User")
+	case "windows":
+		t.Setenv("APPDATA", filepath.Join(home, "AppData", "Roaming"))
+		vscodeAlt = filepath.Join(home, "AppData", "Roaming", "Code", "User")
+	default:
+		t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg"))
+		vscodeAlt = filepath.Join(home, "xdg", "Code", "User")
+	}
 	os.MkdirAll(vscodeAlt, 0755)
 	// Create claude config file
 	claude := filepath.Join(home, ".claude", "config.json")
 	os.MkdirAll(filepath.Dir(claude), 0755)
 	os.WriteFile(claude, []byte("{}"), 0644)
 
-	found := DetectApplications()
+	found := DetectApplications(AppTemplates)
 	if _, ok := found["claude"]; !ok {
 		t.Fatalf("claude not detected")
 	}
 	if _, ok := found["vscode"]; !ok {
 		t.Fatalf("vscode not detected")
 	}
-	// If default AuthPath (~/.vscode/User) absent, AuthPath should equal the detected path
+	// If default AuthPath (~/.vscode/User) absent, AuthPath should be the platform path
 	gotPath := filepath.Clean(expandPath(found["vscode"].AuthPath))
 	wantPath := filepath.Clean(vscodeAlt)
 	if gotPath != wantPath {
@@ -1524,7 +2104,7 @@ func TestRunWizard_ManualFolder_DefaultPattern(t *testing.T) {
 	if !ok {
 		t.Fatalf("folderapp missing from config")
 	}
-	backup := resolveSwitchPattern(app.SwitchPattern, confDir, "p1")
+	backup := resolveSwitchPattern(app.SwitchPattern, "folderapp", confDir, "p1")
 	// Expect the file under profiles/{name}.switch/a.txt
 	if _, err := os.Stat(filepath.Join(backup, "a.txt")); err != nil {
 		t.Fatalf("expected copied file in backup dir: %v", err)
@@ -1794,6 +2374,12 @@ func TestPrintHelp_ContainsNewCommands(t *testing.T) {
 	if !strings.Contains(out, "switch <app> config") {
 		t.Fatalf("help should contain app config command: %q", out)
 	}
+
+	for name := range AppTemplates {
+		if !strings.Contains(out, name) {
+			t.Fatalf("help should list template %s: %q", name, out)
+		}
+	}
 }
 
 // Cross-platform editor detection test
@@ -1840,3 +2426,236 @@ func TestOpenConfig_EditorDetection(t *testing.T) {
 		t.Fatalf("OpenConfig should succeed with fake nano: %v", err)
 	}
 }
+
+// Profile management
+func TestRemoveAccount(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	if err := s.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	// Declining keeps everything
+	withStdin(t, "n\n", func() {
+		if err := s.RemoveAccount("codex", "b", false); err == nil {
+			t.Fatalf("expected cancellation")
+		}
+	})
+	if _, err := os.Stat(authPath + ".b.switch"); err != nil {
+		t.Fatalf("snapshot removed despite cancel: %v", err)
+	}
+	// Confirming removes the account and its snapshot
+	withStdin(t, "y\n", func() {
+		if err := s.RemoveAccount("codex", "b", false); err != nil {
+			t.Fatalf("RemoveAccount: %v", err)
+		}
+	})
+	if _, err := os.Stat(authPath + ".b.switch"); !os.IsNotExist(err) {
+		t.Fatalf("snapshot not deleted, err=%v", err)
+	}
+	s2, _ := newTestSwitcher(t, home)
+	if app, _ := s2.GetAppConfig("codex"); contains(app.Accounts, "b") || app.Current != "a" {
+		t.Fatalf("config not updated: %+v", app)
+	}
+	// Removing the current profile clears Current but keeps the live config
+	out, _ := captureOutput(t, func() {
+		if err := s.RemoveAccount("codex", "a", true); err != nil {
+			t.Fatalf("RemoveAccount current: %v", err)
+		}
+	})
+	if !strings.Contains(out, "was the current profile") {
+		t.Fatalf("expected current profile note, got %q", out)
+	}
+	if app, _ := s.GetAppConfig("codex"); app.Current != "" || len(app.Accounts) != 0 {
+		t.Fatalf("current not cleared: %+v", app)
+	}
+	if b, _ := os.ReadFile(authPath); string(b) != `{"token":"a"}` {
+		t.Fatalf("live config changed: %s", string(b))
+	}
+	// Errors
+	if err := s.RemoveAccount("codex", "ghost", true); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err := s.RemoveAccount("nosuch", "a", true); err == nil {
+		t.Fatalf("expected error for unknown app")
+	}
+}
+
+func TestHandleRemove(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "b": `{"token":"b"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "b"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	if code := handleRemove(s, []string{"codex"}); code != 1 {
+		t.Fatalf("expected usage error, got %d", code)
+	}
+	if code := handleRemove(s, []string{"codex", "b", "--yes"}); code != 0 {
+		t.Fatalf("handleRemove --yes failed: %d", code)
+	}
+	if code := handleApp(s, "codex", []string{"remove", "a"}); code != 1 {
+		t.Fatalf("expected cancel without confirmation input, got %d", code)
+	}
+	if _, err := os.Stat(authPath + ".a.switch"); err != nil {
+		t.Fatalf("snapshot a should remain after cancelled remove: %v", err)
+	}
+}
+
+func TestRenameAccount(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`, "m": `{"token":"m"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a", "m"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	if err := s.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RenameAccount("codex", "a", "z"); err != nil {
+		t.Fatalf("RenameAccount: %v", err)
+	}
+	if _, err := os.Stat(authPath + ".z.switch"); err != nil {
+		t.Fatalf("snapshot not moved: %v", err)
+	}
+	if _, err := os.Stat(authPath + ".a.switch"); !os.IsNotExist(err) {
+		t.Fatalf("old snapshot still present, err=%v", err)
+	}
+	app, _ := s.GetAppConfig("codex")
+	if strings.Join(app.Accounts, ",") != "m,z" || app.Current != "z" {
+		t.Fatalf("config not updated: %+v", app)
+	}
+	// Collisions are refused
+	if err := s.RenameAccount("codex", "z", "m"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected collision error, got %v", err)
+	}
+	os.WriteFile(authPath+".q.switch", []byte("{}"), 0600)
+	if err := s.RenameAccount("codex", "z", "q"); err == nil || !strings.Contains(err.Error(), "snapshot already exists") {
+		t.Fatalf("expected snapshot collision error, got %v", err)
+	}
+	if err := s.RenameAccount("codex", "ghost", "x"); err == nil {
+		t.Fatalf("expected not found error")
+	}
+	if err := s.RenameAccount("codex", "z", ""); err == nil {
+		t.Fatalf("expected empty name error")
+	}
+	for _, name := range []string{".", "..", "a/b", `a\b`} {
+		if err := s.RenameAccount("codex", "z", name); err == nil {
+			t.Fatalf("expected %q to be rejected", name)
+		}
+	}
+}
+
+func TestRenameApp(t *testing.T) {
+	home := setHome(t)
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	s.SetAppConfig("git", AppConfig{Accounts: []string{}, AuthPath: "~/.gitconfig", SwitchPattern: "{auth_path}.{name}.switch"})
+	s.config.Default.Config = "codex"
+	if err := s.RenameApp("codex", "openai"); err != nil {
+		t.Fatalf("RenameApp: %v", err)
+	}
+	if _, ok := s.GetAppConfig("codex"); ok {
+		t.Fatalf("old app still present")
+	}
+	if app, ok := s.GetAppConfig("openai"); !ok || app.Current != "a" {
+		t.Fatalf("renamed app missing or changed: %+v", app)
+	}
+	s2, _ := newTestSwitcher(t, home)
+	if s2.config.Default.Config != "openai" {
+		t.Fatalf("default not updated: %q", s2.config.Default.Config)
+	}
+	if err := s.RenameApp("openai", "git"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected collision error, got %v", err)
+	}
+	if err := s.RenameApp("nosuch", "x"); err == nil {
+		t.Fatalf("expected not found error")
+	}
+	if code := handleRename(s, []string{"openai"}); code != 1 {
+		t.Fatalf("expected usage error, got %d", code)
+	}
+	if code := handleRename(s, []string{"openai", "codex"}); code != 0 {
+		t.Fatalf("handleRename app failed: %d", code)
+	}
+}
+
+func TestSaveCurrent(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, map[string]string{"a": `{"token":"a"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	out, _ := captureOutput(t, func() {
+		changed, err := s.SaveCurrent("codex")
+		if err != nil || changed {
+			t.Fatalf("expected unchanged save, got changed=%v err=%v", changed, err)
+		}
+	})
+	if !strings.Contains(out, "already up to date") {
+		t.Fatalf("expected up to date message, got %q", out)
+	}
+	// Simulate a token refresh
+	os.WriteFile(authPath, []byte(`{"token":"refreshed"}`), 0600)
+	changed, err := s.SaveCurrent("codex")
+	if err != nil || !changed {
+		t.Fatalf("expected changed save, got changed=%v err=%v", changed, err)
+	}
+	if b, _ := os.ReadFile(authPath + ".a.switch"); string(b) != `{"token":"refreshed"}` {
+		t.Fatalf("snapshot not updated: %s", string(b))
+	}
+	// No current profile
+	s.SetAppConfig("codex", AppConfig{Current: "", Accounts: []string{"a"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	if _, err := s.SaveCurrent("codex"); err == nil || !strings.Contains(err.Error(), "no current profile") {
+		t.Fatalf("expected no current profile error, got %v", err)
+	}
+	if _, err := s.SaveCurrent("nosuch"); err == nil {
+		t.Fatalf("expected error for unknown app")
+	}
+}
+
+func TestSaveCurrent_LiveMatchesOtherProfile(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"a"}`, nil)
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Accounts: []string{}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	if err := s.AddAccount("codex", "a"); err != nil {
+		t.Fatal(err)
+	}
+	// Log in as b and add it; the recorded current profile stays a.
+	os.WriteFile(authPath, []byte(`{"token":"b"}`), 0600)
+	if err := s.AddAccount("codex", "b"); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := captureOutput(t, func() {
+		changed, err := s.SaveCurrent("codex")
+		if err != nil || changed {
+			t.Fatalf("expected unchanged save, got changed=%v err=%v", changed, err)
+		}
+	})
+	if !strings.Contains(out, "Profile b for codex is already up to date") {
+		t.Fatalf("expected b to be reported up to date, got %q", out)
+	}
+	if b, _ := os.ReadFile(authPath + ".a.switch"); string(b) != `{"token":"a"}` {
+		t.Fatalf("profile a overwritten: %s", b)
+	}
+}
+
+func TestHandleSave(t *testing.T) {
+	home := setHome(t)
+	authPath := setupCodexFiles(t, home, `{"token":"new"}`, map[string]string{"a": `{"token":"old"}`})
+	s, _ := newTestSwitcher(t, home)
+	s.SetAppConfig("codex", AppConfig{Current: "a", Accounts: []string{"a"}, AuthPath: "~/.codex/auth.json", SwitchPattern: "{auth_path}.{name}.switch"})
+	s.config.Default.Config = "codex"
+	if code := handleSave(s, nil); code != 0 {
+		t.Fatalf("handleSave default app failed: %d", code)
+	}
+	if b, _ := os.ReadFile(authPath + ".a.switch"); string(b) != `{"token":"new"}` {
+		t.Fatalf("snapshot not saved: %s", string(b))
+	}
+	if code := handleApp(s, "codex", []string{"save"}); code != 0 {
+		t.Fatalf("handleApp save failed: %d", code)
+	}
+	if code := handleSave(s, []string{"a", "b"}); code != 1 {
+		t.Fatalf("expected usage error, got %d", code)
+	}
+	s.config.Default.Config = ""
+	if code := handleSave(s, nil); code != 1 {
+		t.Fatalf("expected error without default app, got %d", code)
+	}
+}
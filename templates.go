@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Template sources, from lowest to highest precedence.
+const (
+	sourceBuiltin = "built-in"
+	sourceConfig  = "config"
+)
+
+// templatesDir returns the folder of user template files:
+// $XDG_CONFIG_HOME/switch/templates, or the platform config directory when
+// XDG_CONFIG_HOME is not set.
+func templatesDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "switch", "templates")
+	}
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("APPDATA"); dir != "" {
+			return filepath.Join(dir, "switch", "templates")
+		}
+	}
+	home, _ := getHomeDir()
+	return filepath.Join(home, ".config", "switch", "templates")
+}
+
+// loadTemplateFile reads one template file. The template is named after the
+// file.
+func loadTemplateFile(path string) (string, AppTemplate, error) {
+	var tpl AppTemplate
+	if _, err := toml.DecodeFile(path, &tpl); err != nil {
+		return "", tpl, err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return name, tpl, nil
+}
+
+// validateTemplate checks a user template and fills in its defaults.
+func validateTemplate(name string, tpl AppTemplate) (AppTemplate, error) {
+	if name == "" || strings.ContainsAny(name, " /\\") {
+		return tpl, fmt.Errorf("invalid template name %q", name)
+	}
+	if tpl.AuthPath == "" && len(tpl.AuthPaths) == 0 {
+		return tpl, fmt.Errorf("template %s: auth_path is required", name)
+	}
+	if len(tpl.DetectPaths) == 0 && tpl.AuthPath != "" {
+		tpl.DetectPaths = []string{tpl.AuthPath}
+	}
+	return tpl, nil
+}
+
+// authCandidates returns the config paths a template may use on this
+// system, most preferred first: the AuthPaths for this GOOS, then those for
+// all systems, then AuthPath. Candidates with unset variables are dropped.
+func authCandidates(tpl AppTemplate) []string {
+	var out []string
+	for _, p := range append(append(append([]string{}, tpl.AuthPaths[runtime.GOOS]...), tpl.AuthPaths["all"]...), tpl.AuthPath) {
+		if _, ok := expandEnv(p); ok && p != "" && !contains(out, p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// resolveTemplate sets the AuthPath of tpl to the first candidate that
+// exists, or to the first usable candidate when none does. It reports
+// whether the app looks installed: a candidate or a detect path exists.
+func resolveTemplate(tpl AppTemplate) (AppTemplate, bool) {
+	candidates := authCandidates(tpl)
+	for _, p := range candidates {
+		if fileOrDirExists(expandPath(p)) {
+			tpl.AuthPath = p
+			return tpl, true
+		}
+	}
+	if len(candidates) > 0 {
+		tpl.AuthPath = candidates[0]
+	}
+	for _, p := range tpl.DetectPaths {
+		if _, ok := expandEnv(p); ok && fileOrDirExists(expandPath(p)) {
+			return tpl, true
+		}
+	}
+	return tpl, false
+}
+
+// templates returns the built-in templates merged with the user's. Template
+// files override built-ins, and templates in the config override both.
+// Invalid user templates are reported and skipped.
+func (s *Switcher) templates() map[string]AppTemplate {
+	merged := make(map[string]AppTemplate, len(AppTemplates))
+	for name, tpl := range AppTemplates {
+		tpl.Source = sourceBuiltin
+		merged[name] = tpl
+	}
+	add := func(name string, tpl AppTemplate, source string) {
+		tpl, err := validateTemplate(name, tpl)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s! Skipping template from %s: %v%s\n", ColorYellow, source, err, ColorReset)
+			return
+		}
+		tpl.Source = source
+		merged[name] = tpl
+	}
+
+	files, _ := filepath.Glob(filepath.Join(templatesDir(), "*.toml"))
+	sort.Strings(files)
+	for _, path := range files {
+		name, tpl, err := loadTemplateFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s! Skipping template %s: %v%s\n", ColorYellow, path, err, ColorReset)
+			continue
+		}
+		add(name, tpl, path)
+	}
+	for name, tpl := range s.config.Templates {
+		add(name, tpl, sourceConfig)
+	}
+	return merged
+}
+
+// ListTemplates prints the available templates and where each comes from.
+func (s *Switcher) ListTemplates() {
+	all := s.templates()
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("%sTemplates:%s\n", ColorCyan, ColorReset)
+	for _, name := range names {
+		tpl, _ := resolveTemplate(all[name])
+		fmt.Printf("  %-10s %-32s %s", name, tpl.AuthPath, tpl.Source)
+		if tpl.Description != "" {
+			fmt.Printf("  %s", tpl.Description)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Add your own under [templates.<name>] in %s or as %s\n",
+		s.configPath, filepath.Join(templatesDir(), "<name>.toml"))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func writeTemplateFile(t *testing.T, home, name, content string) {
+	t.Helper()
+	dir := filepath.Join(home, ".config", "switch", "templates")
+	os.MkdirAll(dir, 0755)
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTemplates_Merge(t *testing.T) {
+	home := setHome(t)
+	writeTemplateFile(t, home, "mytool.toml", "auth_path = \"~/.mytool/token\"\ndescription = \"From file\"\n")
+	writeTemplateFile(t, home, "git.toml", "auth_path = \"~/.config/git/config\"\n")
+	writeTemplateFile(t, home, "broken.toml", "auth_path = \n")
+	writeTemplateFile(t, home, "nopath.toml", "description = \"missing auth path\"\n")
+
+	s, _ := newTestSwitcher(t, home)
+	s.config.Templates = map[string]AppTemplate{
+		"mytool": {AuthPath: "~/.mytool/credentials.json", Description: "From config"},
+	}
+	var all map[string]AppTemplate
+	_, stderr := captureOutput(t, func() { all = s.templates() })
+
+	if all["codex"].Source != sourceBuiltin {
+		t.Fatalf("expected built-in codex, got %q", all["codex"].Source)
+	}
+	if tpl := all["git"]; tpl.AuthPath != "~/.config/git/config" || !strings.HasSuffix(tpl.Source, "git.toml") {
+		t.Fatalf("template file did not override built-in: %+v", tpl)
+	}
+	if tpl := all["mytool"]; tpl.Description != "From config" || tpl.Source != sourceConfig {
+		t.Fatalf("config template did not override file: %+v", tpl)
+	}
+	if tpl := all["mytool"]; len(tpl.DetectPaths) != 1 || tpl.DetectPaths[0] != tpl.AuthPath {
+		t.Fatalf("detect paths not defaulted: %+v", tpl)
+	}
+	if _, ok := all["broken"]; ok {
+		t.Fatalf("invalid template file loaded")
+	}
+	if _, ok := all["nopath"]; ok {
+		t.Fatalf("template without auth path loaded")
+	}
+	if !strings.Contains(stderr, "broken.toml") || !strings.Contains(stderr, "auth_path is required") {
+		t.Fatalf("invalid templates not reported: %q", stderr)
+	}
+}
+
+func TestTemplates_AddAndDetect(t *testing.T) {
+	home := setHome(t)
+	token := filepath.Join(home, ".mytool", "token")
+	os.MkdirAll(filepath.Dir(token), 0755)
+	os.WriteFile(token, []byte("secret"), 0600)
+
+	s, _ := newTestSwitcher(t, home)
+	s.config.Templates = map[string]AppTemplate{
+		"mytool": {AuthPath: "~/.mytool/token", Description: "MyTool token"},
+	}
+	if _, ok := DetectApplications(s.templates())["mytool"]; !ok {
+		t.Fatalf("user template not detected")
+	}
+	if err := s.AddAccount("mytool", "work"); err != nil {
+		t.Fatal(err)
+	}
+	appConfig, ok := s.GetAppConfig("mytool")
+	if !ok || appConfig.AuthPath != "~/.mytool/token" {
+		t.Fatalf("app not created from user template: %+v", appConfig)
+	}
+	if !fileOrDirExists(filepath.Join(home, ".local", "share", "switch", "mytool", "work")) {
+		t.Fatalf("snapshot not stored in the central store")
+	}
+
+	out, _ := captureOutput(t, func() { s.ListTemplates() })
+	if !strings.Contains(out, "mytool") || !strings.Contains(out, "config") || !strings.Contains(out, "built-in") {
+		t.Fatalf("unexpected template list: %q", out)
+	}
+}
+
+func TestTemplates_ConfigRoundTrip(t *testing.T) {
+	home := setHome(t)
+	s, _ := newTestSwitcher(t, home)
+	s.config.Templates = map[string]AppTemplate{
+		"mytool": {AuthPath: "~/.mytool/token", IgnoreKeys: []string{"ts"}, Source: "ignored"},
+	}
+	if err := s.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(home, ".switch.toml"))
+	if !strings.Contains(string(data), "[templates.mytool]") || strings.Contains(string(data), "ignored") {
+		t.Fatalf("unexpected saved config:\n%s", data)
+	}
+	s2, err := newTestSwitcher(t, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tpl := s2.config.Templates["mytool"]; tpl.AuthPath != "~/.mytool/token" || len(tpl.IgnoreKeys) != 1 {
+		t.Fatalf("template not loaded back: %+v", tpl)
+	}
+}
+
+func TestDetectApplications_CLITemplates(t *testing.T) {
+	home := setHome(t)
+	write := func(rel, data string) {
+		p := filepath.Join(home, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte(data), 0600)
+	}
+	write(".aws/credentials", "[default]\naws_access_key_id = WORK\n")
+	write(".aws/cli/cache/abc.json", "{}")
+	write(".config/gh/hosts.yml", "github.com:\n  user: work\n")
+	write(".pip/pip.conf", "[global]\nindex-url = https://work.example/simple\n")
+
+	found := DetectApplications(AppTemplates)
+	for _, name := range []string{"aws", "gh", "pip"} {
+		if _, ok := found[name]; !ok {
+			t.Fatalf("%s not detected", name)
+		}
+	}
+	for _, name := range []string{"kube", "docker", "terraform", "azure", "gcloud", "npm"} {
+		if _, ok := found[name]; ok {
+			t.Fatalf("%s detected without its config", name)
+		}
+	}
+	if got := found["aws"].AuthPath; got != "~/.aws" {
+		t.Fatalf("aws should switch the whole folder, got %q", got)
+	}
+	if got := filepath.Clean(expandPath(found["pip"].AuthPath)); got != filepath.Join(home, ".pip", "pip.conf") {
+		t.Fatalf("pip AuthPath not set to detected path: %q", got)
+	}
+
+	s, _ := newTestSwitcher(t, home)
+	if err := s.AddAccount("aws", "work"); err != nil {
+		t.Fatal(err)
+	}
+	snap := filepath.Join(home, ".local", "share", "switch", "aws", "work")
+	if !fileOrDirExists(filepath.Join(snap, "credentials")) || fileOrDirExists(filepath.Join(snap, "cli")) {
+		t.Fatalf("aws snapshot should hold credentials without CLI caches")
+	}
+}
+
+func TestAddAccount_TemplateUsesDetectedPath(t *testing.T) {
+	home := setHome(t)
+	legacy := filepath.Join(home, ".pip", "pip.conf")
+	os.MkdirAll(filepath.Dir(legacy), 0755)
+	os.WriteFile(legacy, []byte("[global]\n"), 0644)
+
+	s, _ := newTestSwitcher(t, home)
+	if err := s.AddAccount("pip", "work"); err != nil {
+		t.Fatal(err)
+	}
+	appConfig, _ := s.GetAppConfig("pip")
+	if got := filepath.Clean(expandPath(appConfig.AuthPath)); got != legacy {
+		t.Fatalf("expected detected legacy path, got %q", got)
+	}
+}
+
+func TestResolveTemplate_Candidates(t *testing.T) {
+	home := setHome(t)
+	t.Setenv("CODEX_HOME", "")
+	tpl, ok := resolveTemplate(AppTemplates["codex"])
+	if ok || tpl.AuthPath != "~/.codex/auth.json" {
+		t.Fatalf("expected undetected codex with default path, got %q %v", tpl.AuthPath, ok)
+	}
+
+	custom := filepath.Join(home, "codex-home")
+	os.MkdirAll(custom, 0755)
+	os.WriteFile(filepath.Join(custom, "auth.json"), []byte("{}"), 0600)
+	t.Setenv("CODEX_HOME", custom)
+	tpl, ok = resolveTemplate(AppTemplates["codex"])
+	if !ok || expandPath(tpl.AuthPath) != filepath.ToSlash(filepath.Join(custom, "auth.json")) {
+		t.Fatalf("expected $CODEX_HOME to be used, got %q %v", tpl.AuthPath, ok)
+	}
+
+	// A per-OS candidate wins over the fallback, and a missing one over
+	// nothing at all
+	own := AppTemplate{
+		AuthPath:  "~/.tool/config",
+		AuthPaths: map[string][]string{runtime.GOOS: {"${SWITCH_TEST_UNSET}/config", "~/.local/tool/config"}},
+	}
+	if tpl, ok := resolveTemplate(own); ok || tpl.AuthPath != "~/.local/tool/config" {
+		t.Fatalf("expected first usable candidate, got %q %v", tpl.AuthPath, ok)
+	}
+	os.MkdirAll(filepath.Join(home, ".tool"), 0755)
+	os.WriteFile(filepath.Join(home, ".tool", "config"), []byte("x"), 0600)
+	if tpl, ok := resolveTemplate(own); !ok || tpl.AuthPath != "~/.tool/config" {
+		t.Fatalf("expected existing fallback, got %q %v", tpl.AuthPath, ok)
+	}
+}
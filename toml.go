@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// tomlEntry is one key assignment of a TOML file. start and end cover its
+// lines including the final newline, and valStart and valEnd its value.
+type tomlEntry struct {
+	path             []string
+	table            []string
+	start, end       int
+	valStart, valEnd int
+}
+
+// tomlTable is a [table] or [[array]] header. end is where its line ends.
+type tomlTable struct {
+	path       []string
+	array      bool
+	start, end int
+}
+
+// tomlFile lists the headers and assignments of a TOML file, so values can
+// be patched without rewriting the rest of the file.
+type tomlFile struct {
+	entries []tomlEntry
+	tables  []tomlTable
+}
+
+// scanTOML finds the headers and assignments of data, which must be valid
+// TOML. It returns errKeepLayout for syntax it does not follow.
+func scanTOML(data []byte) (*tomlFile, error) {
+	f := &tomlFile{}
+	var table []string
+	for pos := 0; pos < len(data); {
+		lineEnd := lineEndAt(data, pos)
+		next := lineEnd
+		if next < len(data) {
+			next++
+		}
+		line := strings.TrimSpace(string(data[pos:lineEnd]))
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "["):
+			array := strings.HasPrefix(line, "[[")
+			inner := strings.TrimPrefix(line, "[")
+			if array {
+				inner = inner[1:]
+			}
+			path, rest, ok := parseTOMLKey(inner)
+			if !ok || !strings.HasPrefix(rest, "]") {
+				return nil, errKeepLayout
+			}
+			table = path
+			f.tables = append(f.tables, tomlTable{path: path, array: array, start: pos, end: next})
+		default:
+			indent := len(data[pos:lineEnd]) - len(bytes.TrimLeft(data[pos:lineEnd], " \t"))
+			key, rest, ok := parseTOMLKey(line)
+			if !ok || !strings.HasPrefix(rest, "=") {
+				return nil, errKeepLayout
+			}
+			valStart := pos + indent + len(line) - len(strings.TrimLeft(rest[1:], " \t"))
+			valEnd := tomlValueEnd(data, valStart)
+			if valEnd < 0 {
+				return nil, errKeepLayout
+			}
+			next = lineEndAt(data, valEnd)
+			if next < len(data) {
+				next++
+			}
+			path := append(append([]string{}, table...), key...)
+			f.entries = append(f.entries, tomlEntry{path: path, table: table, start: pos, end: next, valStart: valStart, valEnd: valEnd})
+		}
+		pos = next
+	}
+	return f, nil
+}
+
+func lineEndAt(data []byte, pos int) int {
+	if i := bytes.IndexByte(data[pos:], '\n'); i >= 0 {
+		return pos + i
+	}
+	return len(data)
+}
+
+// parseTOMLKey reads a bare, quoted or dotted key from the start of s and
+// returns its parts and what follows it.
+func parseTOMLKey(s string) ([]string, string, bool) {
+	var parts []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		switch {
+		case strings.HasPrefix(s, `"`):
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, "", false
+			}
+			part, err := strconv.Unquote(s[:end+1])
+			if err != nil {
+				return nil, "", false
+			}
+			parts = append(parts, part)
+			s = s[end+1:]
+		case strings.HasPrefix(s, "'"):
+			end := strings.IndexByte(s[1:], '\'')
+			if end < 0 {
+				return nil, "", false
+			}
+			parts = append(parts, s[1:end+1])
+			s = s[end+2:]
+		default:
+			end := 0
+			for end < len(s) && (isBareKeyChar(s[end])) {
+				end++
+			}
+			if end == 0 {
+				return nil, "", false
+			}
+			parts = append(parts, s[:end])
+			s = s[end:]
+		}
+		s = strings.TrimLeft(s, " \t")
+		if !strings.HasPrefix(s, ".") {
+			return parts, s, true
+		}
+		s = s[1:]
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// tomlValueEnd returns where the value starting at start ends: the shortest
+// text, ending before a comment or at a line end, that parses as a value.
+// Values may span lines, such as arrays and multi-line strings.
+func tomlValueEnd(data []byte, start int) int {
+	for lineStart := start; lineStart < len(data); {
+		lineEnd := lineEndAt(data, lineStart)
+		for pos := lineStart; pos <= lineEnd; pos++ {
+			if pos < lineEnd && data[pos] != '#' {
+				continue
+			}
+			if _, ok := tomlValue(data[start:pos]); ok {
+				return start + len(bytes.TrimRight(data[start:pos], " \t\r"))
+			}
+		}
+		lineStart = lineEnd + 1
+	}
+	return -1
+}
+
+// tomlValue decodes a single TOML value.
+func tomlValue(text []byte) (interface{}, bool) {
+	doc := map[string]interface{}{}
+	if _, err := toml.Decode("x = "+string(text)+"\n", &doc); err != nil {
+		return nil, false
+	}
+	return normalizeTOML(doc["x"]), true
+}
+
+// normalizeTOML turns the arrays of tables that the decoder returns as
+// []map[string]interface{} into []interface{}, like every other array, so
+// pointers can index them.
+func normalizeTOML(v interface{}) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for k, child := range node {
+			node[k] = normalizeTOML(child)
+		}
+	case []interface{}:
+		for i, child := range node {
+			node[i] = normalizeTOML(child)
+		}
+	case []map[string]interface{}:
+		out := make([]interface{}, len(node))
+		for i, child := range node {
+			out[i] = normalizeTOML(child)
+		}
+		return out
+	}
+	return v
+}
+
+// renderTOML encodes key = value on one line. Values that need a table of
+// their own cannot be written in place.
+func renderTOML(key string, value interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(map[string]interface{}{key: value}); err != nil {
+		return "", err
+	}
+	line := strings.TrimSuffix(buf.String(), "\n")
+	if strings.HasPrefix(line, "[") || strings.Contains(line, "\n") {
+		return "", errKeepLayout
+	}
+	return line, nil
+}
+
+// patchTOML patches the selected keys of profile into live by editing only
+// the lines of those keys, so comments and the order of everything else are
+// kept. Whole tables, arrays of tables and keys whose table is not in live
+// fall back to rewriting the file.
+func patchTOML(live, profile []byte, keys [][]string) ([]byte, error) {
+	if len(bytes.TrimSpace(live)) == 0 {
+		return nil, errKeepLayout
+	}
+	if _, err := decodeTOML(live); err != nil {
+		return nil, fmt.Errorf("live config: %w", err)
+	}
+	stored, err := decodeTOML(profile)
+	if err != nil {
+		return nil, fmt.Errorf("profile: %w", err)
+	}
+	out := live
+	for _, tokens := range keys {
+		value, ok := lookupKey(stored, tokens)
+		if out, err = patchTOMLKey(out, tokens, value, ok); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// patchTOMLKey sets the value at tokens in data, or removes it when present
+// is false.
+func patchTOMLKey(data []byte, tokens []string, value interface{}, present bool) ([]byte, error) {
+	f, err := scanTOML(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range f.tables {
+		if pathHasPrefix(t.path, tokens) || (t.array && pathHasPrefix(tokens, t.path)) {
+			return nil, errKeepLayout
+		}
+	}
+	var found *tomlEntry
+	for i, e := range f.entries {
+		if pathHasPrefix(e.path, tokens) && len(e.path) == len(tokens) {
+			found = &f.entries[i]
+		} else if pathHasPrefix(e.path, tokens) || pathHasPrefix(tokens, e.path) {
+			return nil, errKeepLayout
+		}
+	}
+	if found != nil {
+		if !present {
+			return splice(data, found.start, found.end, nil), nil
+		}
+		if old, ok := tomlValue(data[found.valStart:found.valEnd]); ok && valuesEqual(old, value) {
+			return data, nil
+		}
+		line, err := renderTOML("x", value)
+		if err != nil {
+			return nil, err
+		}
+		return splice(data, found.valStart, found.valEnd, []byte(strings.TrimPrefix(line, "x = "))), nil
+	}
+	if !present {
+		return data, nil
+	}
+
+	// Add the key after the last assignment of its table.
+	table := tokens[:len(tokens)-1]
+	at := -1
+	if len(table) == 0 {
+		at = 0
+	}
+	for _, t := range f.tables {
+		if !t.array && len(t.path) == len(table) && pathHasPrefix(t.path, table) {
+			at = t.end
+		}
+	}
+	if at < 0 {
+		return nil, errKeepLayout
+	}
+	indent := ""
+	for _, e := range f.entries {
+		if len(e.table) == len(table) && pathHasPrefix(e.table, table) {
+			at, indent = e.end, lineIndent(data, e.valStart)
+		}
+	}
+	line, err := renderTOML(tokens[len(tokens)-1], value)
+	if err != nil {
+		return nil, err
+	}
+	text := indent + line + "\n"
+	if at > 0 && data[at-1] != '\n' {
+		text = "\n" + text
+	}
+	return splice(data, at, at, []byte(text)), nil
+}
+
+// pathHasPrefix reports whether path starts with prefix.
+func pathHasPrefix(path, prefix []string) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// patchYAML patches the selected keys of profile into live through the
+// yaml.v3 node tree, so comments and the order of keys are kept. A scalar
+// replaced by another scalar is rewritten in place, keeping the rest of the
+// file byte for byte; other changes re-encode the tree, which normalizes the
+// indentation.
+func patchYAML(live, profile []byte, keys [][]string) ([]byte, error) {
+	if len(bytes.TrimSpace(live)) == 0 {
+		return nil, errKeepLayout
+	}
+	stored, err := decodeYAML(profile)
+	if err != nil {
+		return nil, fmt.Errorf("profile: %w", err)
+	}
+	out := live
+	for _, tokens := range keys {
+		value, ok := lookupKey(stored, tokens)
+		if out, err = patchYAMLKey(out, tokens, value, ok); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// patchYAMLKey sets the value at tokens in data, or removes it when present
+// is false.
+func patchYAMLKey(data []byte, tokens []string, value interface{}, present bool) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("live config: parse YAML: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errKeepLayout
+	}
+	parent := doc.Content[0]
+	for i, tok := range tokens {
+		node, index := yamlChild(parent, tok)
+		if node == nil {
+			if !present {
+				return data, nil
+			}
+			var add yaml.Node
+			if err := add.Encode(setIn(nil, tokens[i+1:], value)); err != nil {
+				return nil, err
+			}
+			switch {
+			case parent.Kind == yaml.MappingNode:
+				var key yaml.Node
+				key.Encode(tok)
+				parent.Content = append(parent.Content, &key, &add)
+			case parent.Kind == yaml.SequenceNode && fmt.Sprint(len(parent.Content)) == tok:
+				parent.Content = append(parent.Content, &add)
+			default:
+				return nil, errKeepLayout
+			}
+			return encodeYAMLNode(&doc, data)
+		}
+		if node.Kind == yaml.AliasNode {
+			return nil, errKeepLayout
+		}
+		if i < len(tokens)-1 {
+			parent = node
+			continue
+		}
+		if !present {
+			if parent.Kind == yaml.MappingNode {
+				parent.Content = append(parent.Content[:2*index], parent.Content[2*index+2:]...)
+			} else {
+				parent.Content = append(parent.Content[:index], parent.Content[index+1:]...)
+			}
+			return encodeYAMLNode(&doc, data)
+		}
+		var old interface{}
+		if err := node.Decode(&old); err == nil && valuesEqual(old, value) {
+			return data, nil
+		}
+		if out, ok := spliceYAMLScalar(data, node, value); ok {
+			return out, nil
+		}
+		var repl yaml.Node
+		if err := repl.Encode(value); err != nil {
+			return nil, err
+		}
+		repl.HeadComment, repl.LineComment, repl.FootComment = node.HeadComment, node.LineComment, node.FootComment
+		*node = repl
+		return encodeYAMLNode(&doc, data)
+	}
+	return data, nil
+}
+
+// yamlChild returns the value of a mapping that tok names, or the element of
+// a sequence, and its index.
+func yamlChild(n *yaml.Node, tok string) (*yaml.Node, int) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		// The last of duplicate keys wins, as when decoding.
+		for i := len(n.Content)/2 - 1; i >= 0; i-- {
+			if n.Content[2*i].Value == tok {
+				return n.Content[2*i+1], i
+			}
+		}
+	case yaml.SequenceNode:
+		if i, ok := arrayIndex(tok); ok && i < len(n.Content) {
+			return n.Content[i], i
+		}
+	}
+	return nil, -1
+}
+
+// spliceYAMLScalar replaces a scalar written on one line with value, if
+// value is a scalar too, leaving every other byte of data alone. Quoted
+// strings keep their quotes.
+func spliceYAMLScalar(data []byte, node *yaml.Node, value interface{}) ([]byte, bool) {
+	if node.Kind != yaml.ScalarNode || node.Style&(yaml.LiteralStyle|yaml.FoldedStyle|yaml.TaggedStyle) != 0 || strings.Contains(node.Value, "\n") {
+		return nil, false
+	}
+	var repl yaml.Node
+	if err := repl.Encode(value); err != nil || repl.Kind != yaml.ScalarNode {
+		return nil, false
+	}
+	if _, isString := value.(string); isString && repl.Style == 0 {
+		repl.Style = node.Style
+	}
+	text, err := yaml.Marshal(&repl)
+	if err != nil {
+		return nil, false
+	}
+	text = bytes.TrimSuffix(text, []byte("\n"))
+	if bytes.Contains(text, []byte("\n")) {
+		return nil, false
+	}
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if node.Line < 1 || node.Line > len(lines) {
+		return nil, false
+	}
+	start := 0
+	for _, line := range lines[:node.Line-1] {
+		start += len(line)
+	}
+	line := lines[node.Line-1]
+	// Columns count characters, not bytes.
+	col := 0
+	for i := 1; i < node.Column && col < len(line); i++ {
+		_, size := utf8.DecodeRune(line[col:])
+		col += size
+	}
+	rest := line[col:]
+	end := -1
+	switch {
+	case node.Style&yaml.DoubleQuotedStyle != 0:
+		for i := 1; i < len(rest); i++ {
+			if rest[i] == '\\' {
+				i++
+			} else if rest[i] == '"' {
+				end = i + 1
+				break
+			}
+		}
+	case node.Style&yaml.SingleQuotedStyle != 0:
+		for i := 1; i < len(rest); i++ {
+			if rest[i] == '\'' {
+				if i+1 < len(rest) && rest[i+1] == '\'' {
+					i++
+					continue
+				}
+				end = i + 1
+				break
+			}
+		}
+	default:
+		end = len(bytes.TrimRight(rest, "\r\n"))
+		if i := bytes.Index(rest, []byte(" #")); i >= 0 {
+			end = i
+		}
+		end = len(bytes.TrimRight(rest[:end], " \t"))
+		if string(rest[:end]) != node.Value {
+			return nil, false
+		}
+	}
+	if end < 0 {
+		return nil, false
+	}
+	return splice(data, start+col, start+col+end, text), true
+}
+
+// encodeYAMLNode encodes doc with the indentation used in live.
+func encodeYAMLNode(doc *yaml.Node, live []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(yamlIndent(live))
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	enc.Close()
+	return buf.Bytes(), nil
+}
+
+// yamlIndent guesses the indentation of a YAML file: the smallest indent of
+// any line, defaulting to two spaces.
+func yamlIndent(data []byte) int {
+	indent := 0
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		body := bytes.TrimLeft(line, " ")
+		if n := len(line) - len(body); n > 0 && len(bytes.TrimSpace(body)) > 0 && body[0] != '#' && (indent == 0 || n < indent) {
+			indent = n
+		}
+	}
+	if indent < 2 {
+		return 2
+	}
+	return indent
+}